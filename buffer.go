@@ -0,0 +1,246 @@
+package main
+
+import (
+	. "strings"
+	"unicode"
+)
+
+// Buffer keeps the note as a tree of lines together with the cursor, so
+// editing does not need to split and join the whole note on every keystroke
+//
+// Lines are stored as runes, columns and offsets count runes and not bytes so
+// the cursor can never end up in the middle of a multi-byte character
+//
+// Every line of the tree knows how many runes come before it, which makes
+// adding and removing lines and converting between row/column and offset
+// O(log n) no matter where in the note they happen
+type Buffer struct {
+	lines lineTree
+
+	// edited is set once the note changed since Edited was last called,
+	// from is the first line that changed and tail how many lines at the end
+	// stayed the same
+	edited bool
+	from   int
+	tail   int
+
+	Row int
+	Col int
+}
+
+func NewBuffer(text string) *Buffer {
+	var lines [][]rune
+	for _, line := range Split(text, "\n") {
+		lines = append(lines, []rune(line))
+	}
+	return &Buffer{lines: newLineTree(lines)}
+}
+
+func (b *Buffer) String() string {
+	var sb Builder
+	b.lines.Each(func(line []rune) {
+		sb.WriteString(string(line))
+		sb.WriteByte('\n')
+	})
+	text := sb.String()
+	return text[:len(text)-1]
+}
+
+func (b *Buffer) Line(row int) string {
+	return string(b.lines.At(row))
+}
+
+func (b *Buffer) LineLen(row int) int {
+	return len(b.lines.At(row))
+}
+
+func (b *Buffer) LineCount() int {
+	return b.lines.Len()
+}
+
+func (b *Buffer) Len() int {
+	return b.lines.Runes()
+}
+
+func (b *Buffer) Offset(row, col int) int {
+	return b.lines.Start(row) + col
+}
+
+func (b *Buffer) Position(offset int) (int, int) {
+	row, start := b.lines.Find(offset)
+	return row, offset - start
+}
+
+func (b *Buffer) Cursor() Cursor {
+	return Cursor{b.Row, b.Col, b.Offset(b.Row, b.Col)}
+}
+
+func (b *Buffer) Pos() int {
+	return b.Offset(b.Row, b.Col)
+}
+
+func (b *Buffer) SetCursor(row, col int) {
+	if row >= b.lines.Len() {
+		row = b.lines.Len() - 1
+	}
+	if row < 0 {
+		row = 0
+	}
+	if n := len(b.lines.At(row)); col > n {
+		col = n
+	}
+	if col < 0 {
+		col = 0
+	}
+	b.Row, b.Col = row, col
+}
+
+func (b *Buffer) MoveTo(offset int) {
+	b.Row, b.Col = b.Position(offset)
+}
+
+// edit remembers that lines from row on changed except for the last tail
+func (b *Buffer) edit(row, tail int) {
+	if !b.edited {
+		b.edited, b.from, b.tail = true, row, tail
+	} else {
+		b.from, b.tail = min(b.from, row), min(b.tail, tail)
+	}
+}
+
+// Edited returns the lines that changed since it was last called, from is
+// the first of them and tail how many lines at the end were left alone, ok
+// is false when nothing changed
+func (b *Buffer) Edited() (from, tail int, ok bool) {
+	from, tail, ok = b.from, b.tail, b.edited
+	b.edited = false
+	return from, tail, ok
+}
+
+// Insert adds text at offset, a cursor at or after offset is moved along
+// with the text that follows it
+func (b *Buffer) Insert(offset int, text string) {
+	if text == "" {
+		return
+	}
+	cursor := b.Pos()
+	row, col := b.Position(offset)
+	line := b.lines.At(row)
+
+	var parts [][]rune
+	for _, part := range Split(text, "\n") {
+		parts = append(parts, []rune(part))
+	}
+	last := len(parts) - 1
+	parts[last] = append(parts[last], line[col:]...)
+	parts[0] = append(append([]rune{}, line[:col]...), parts[0]...)
+
+	b.lines.Replace(row, 1, parts)
+	b.edit(row, b.lines.Len()-row-len(parts))
+
+	if cursor >= offset {
+		cursor += length(text)
+	}
+	b.MoveTo(cursor)
+}
+
+// Text returns n runes starting at offset
+func (b *Buffer) Text(offset, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	row, col := b.Position(offset)
+	endRow, endCol := b.Position(offset + n)
+	if row == endRow {
+		return string(b.lines.At(row)[col:endCol])
+	}
+
+	var sb Builder
+	sb.WriteString(string(b.lines.At(row)[col:]) + "\n")
+	for r := row + 1; r < endRow; r++ {
+		sb.WriteString(string(b.lines.At(r)) + "\n")
+	}
+	sb.WriteString(string(b.lines.At(endRow)[:endCol]))
+	return sb.String()
+}
+
+// Delete removes n runes starting at offset and returns the removed text
+func (b *Buffer) Delete(offset, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	cursor := b.Pos()
+	row, col := b.Position(offset)
+	endRow, endCol := b.Position(offset + n)
+	removed := b.Text(offset, n)
+
+	joined := append(append([]rune{}, b.lines.At(row)[:col]...), b.lines.At(endRow)[endCol:]...)
+	b.lines.Replace(row, endRow-row+1, [][]rune{joined})
+	b.edit(row, b.lines.Len()-row-1)
+
+	if cursor > offset+n {
+		cursor -= n
+	} else if cursor > offset {
+		cursor = offset
+	}
+	b.MoveTo(cursor)
+
+	return removed
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// WordLeft moves the cursor to the start of the previous word, a run of
+// punctuation counts as a word of its own
+func (b *Buffer) WordLeft() {
+	if b.Col == 0 {
+		if b.Row > 0 {
+			b.Row--
+			b.Col = b.LineLen(b.Row)
+		}
+		return
+	}
+	line := b.lines.At(b.Row)
+	i := b.Col
+	for i > 0 && unicode.IsSpace(line[i-1]) {
+		i--
+	}
+	if i > 0 && isWordChar(line[i-1]) {
+		for i > 0 && isWordChar(line[i-1]) {
+			i--
+		}
+	} else {
+		for i > 0 && !isWordChar(line[i-1]) && !unicode.IsSpace(line[i-1]) {
+			i--
+		}
+	}
+	b.Col = i
+}
+
+// WordRight moves the cursor past the end of the next word
+func (b *Buffer) WordRight() {
+	line := b.lines.At(b.Row)
+	if b.Col >= len(line) {
+		if b.Row < b.lines.Len()-1 {
+			b.Row++
+			b.Col = 0
+		}
+		return
+	}
+	i := b.Col
+	for i < len(line) && unicode.IsSpace(line[i]) {
+		i++
+	}
+	if i < len(line) && isWordChar(line[i]) {
+		for i < len(line) && isWordChar(line[i]) {
+			i++
+		}
+	} else {
+		for i < len(line) && !isWordChar(line[i]) && !unicode.IsSpace(line[i]) {
+			i++
+		}
+	}
+	b.Col = i
+}
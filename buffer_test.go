@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBufferEdits(t *testing.T) {
+	tests := []struct {
+		text   string
+		insert bool
+		offset int
+		n      int
+		with   string
+		want   string
+	}{
+		{"", true, 0, 0, "a", "a"},
+		{"ab", true, 1, 0, "\n", "a\nb"},
+		{"ab\ncd", true, 3, 0, "x\ny\n", "ab\nx\ny\ncd"},
+		{"ab\ncd", true, 5, 0, "\n", "ab\ncd\n"},
+		{"é日\nb", true, 2, 0, "x", "é日x\nb"},
+		{"ab\ncd", false, 2, 1, "", "abcd"},
+		{"ab\ncd\nef", false, 1, 5, "", "aef"},
+		{"ab\ncd", false, 0, 5, "", ""},
+		{"a\n\n\nb", false, 1, 2, "", "a\nb"},
+	}
+	for _, test := range tests {
+		b := NewBuffer(test.text)
+		if test.insert {
+			b.Insert(test.offset, test.with)
+		} else if removed := b.Delete(test.offset, test.n); removed != string([]rune(test.text)[test.offset:test.offset+test.n]) {
+			t.Errorf("Delete(%d, %d) in %q removed %q", test.offset, test.n, test.text, removed)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("edit of %q gave %q, want %q", test.text, got, test.want)
+		}
+		if b.Len() != len([]rune(test.want)) || b.LineCount() != strings.Count(test.want, "\n")+1 {
+			t.Errorf("edit of %q gave %d runes and %d lines", test.text, b.Len(), b.LineCount())
+		}
+	}
+}
+
+// TestBufferRandom runs random edits on a buffer and a plain string side by
+// side, then undoes them all
+func TestBufferRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	original := "first\nsecond line\n\né日本\nlast"
+	text := []rune(original)
+	b := NewBuffer(original)
+	var history History
+
+	for i := 0; i < 5000; i++ {
+		offset := r.Intn(len(text) + 1)
+		before := b.Cursor()
+		if r.Intn(2) == 0 {
+			with := []string{"x", "\n", "ab\ncd", "\n\n", "é", "日本\n"}[r.Intn(6)]
+			b.Insert(offset, with)
+			history.Record("", Edit{Pos: offset, Inserted: with}, before, b.Cursor())
+			text = append(text[:offset:offset], append([]rune(with), text[offset:]...)...)
+		} else {
+			n := min(r.Intn(5), len(text)-offset)
+			removed := b.Delete(offset, n)
+			history.Record("", Edit{Pos: offset, Removed: removed}, before, b.Cursor())
+			text = append(text[:offset:offset], text[offset+n:]...)
+		}
+		history.Break()
+
+		if b.String() != string(text) {
+			t.Fatalf("step %d: buffer %q, want %q", i, b.String(), string(text))
+		}
+		lines := strings.Split(string(text), "\n")
+		if b.LineCount() != len(lines) || b.Len() != len(text) {
+			t.Fatalf("step %d: %d lines and %d runes, want %d and %d", i, b.LineCount(), b.Len(), len(lines), len(text))
+		}
+		start := 0
+		for row, line := range lines {
+			if b.Offset(row, 0) != start || b.Line(row) != line {
+				t.Fatalf("step %d: line %d starts at %d as %q, want %d as %q", i, row, b.Offset(row, 0), b.Line(row), start, line)
+			}
+			start += len([]rune(line)) + 1
+		}
+		for offset := 0; offset <= len(text); offset += 1 + r.Intn(7) {
+			row, col := b.Position(offset)
+			if b.Offset(row, col) != offset || col > b.LineLen(row) {
+				t.Fatalf("step %d: offset %d gave %d:%d", i, offset, row, col)
+			}
+		}
+	}
+
+	for history.Undo(b) {
+	}
+	if b.String() != original {
+		t.Errorf("undoing every edit gave %q, want %q", b.String(), original)
+	}
+}
+
+func TestBufferEdited(t *testing.T) {
+	b := NewBuffer("a\nb\nc\nd")
+	if _, _, ok := b.Edited(); ok {
+		t.Errorf("a new buffer reports edits")
+	}
+	b.Insert(b.Offset(1, 1), "x\ny")
+	b.Delete(b.Offset(0, 0), 1)
+	if from, tail, ok := b.Edited(); !ok || from != 0 || tail != 2 {
+		t.Errorf("Edited() = %d, %d, %v, want 0, 2, true", from, tail, ok)
+	}
+	if _, _, ok := b.Edited(); ok {
+		t.Errorf("edits are reported twice")
+	}
+}
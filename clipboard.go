@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	. "strings"
+	"time"
+)
+
+var (
+	// CLIPBOARD picks where copied text goes besides the internal clipboard,
+	// "internal" keeps it inside ScratchPad, "osc52" asks the terminal to set
+	// the system clipboard, "command" runs CLIPBOARD_COPY and CLIPBOARD_PASTE
+	// and "auto" uses a known clipboard command if one is installed and falls
+	// back to osc52 otherwise
+	CLIPBOARD       = "auto"
+	CLIPBOARD_COPY  = ""
+	CLIPBOARD_PASTE = ""
+)
+
+// knownClipboards are tried in order by "auto", the environment variable has
+// to be set for the command to be able to reach a clipboard
+var knownClipboards = []struct {
+	env   string
+	copy  string
+	paste string
+}{
+	{"WAYLAND_DISPLAY", "wl-copy", "wl-paste --no-newline"},
+	{"DISPLAY", "xclip -selection clipboard", "xclip -selection clipboard -o"},
+	{"DISPLAY", "xsel --clipboard --input", "xsel --clipboard --output"},
+	{"", "pbcopy", "pbpaste"},
+}
+
+// clipboardCommands returns the copy and paste commands to use, both are
+// empty when no command is configured or installed
+func clipboardCommands() (string, string) {
+	if CLIPBOARD_COPY != "" || CLIPBOARD_PASTE != "" {
+		return CLIPBOARD_COPY, CLIPBOARD_PASTE
+	}
+	for _, known := range knownClipboards {
+		if known.env != "" && os.Getenv(known.env) == "" {
+			continue
+		}
+		if _, err := exec.LookPath(Fields(known.copy)[0]); err == nil {
+			return known.copy, known.paste
+		}
+	}
+	return "", ""
+}
+
+// runClipboard runs a clipboard command with input on its stdin, it is given
+// a second so a clipboard that cannot be reached does not hang the editor
+//
+// Copy commands like wl-copy and xclip stay in the background to serve the
+// clipboard, their output is not read since it would only close once they
+// exit
+func runClipboard(command string, input string, output bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	args := Fields(command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = NewReader(input)
+	if !output {
+		return "", cmd.Run()
+	}
+	text, err := cmd.Output()
+	return string(text), err
+}
+
+// copySystem puts text on the system clipboard
+func copySystem(text string) error {
+	command, _ := clipboardCommands()
+	if CLIPBOARD == "osc52" || CLIPBOARD == "auto" && command == "" {
+		os.Stdout.WriteString("\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\x07")
+		return nil
+	}
+	if CLIPBOARD == "internal" || command == "" {
+		return nil
+	}
+	_, err := runClipboard(command, text, false)
+	return err
+}
+
+// pasteSystem returns what is on the system clipboard, ok is false when it
+// cannot be read and the internal clipboard should be used instead
+//
+// Terminals rarely allow reading the clipboard through osc52, so with it
+// text copied in other programs is pasted with the terminal's own paste
+func pasteSystem() (string, bool) {
+	_, command := clipboardCommands()
+	if CLIPBOARD == "internal" || CLIPBOARD == "osc52" || command == "" {
+		return "", false
+	}
+	text, err := runClipboard(command, "", true)
+	if err != nil {
+		return "", false
+	}
+	return ReplaceAll(text, "\r\n", "\n"), true
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseConfigComments(t *testing.T) {
+	idle, line := AUTOSAVE_IDLE, AUTOSAVE_LINE
+	defer func() { AUTOSAVE_IDLE, AUTOSAVE_LINE = idle, line }()
+
+	// the lines are the ones the README shows, comments included
+	parseConfig("autosave_idle 5    # Save a note 5 seconds after the last change\nautosave_line true # Save when the cursor leaves a changed line\n")
+	if AUTOSAVE_IDLE != 5 || !AUTOSAVE_LINE {
+		t.Errorf("autosave_idle %d, autosave_line %v, want 5 and true", AUTOSAVE_IDLE, AUTOSAVE_LINE)
+	}
+	parseConfig("autosave_idle\t0#off\n")
+	if AUTOSAVE_IDLE != 0 {
+		t.Errorf("autosave_idle %d, want 0", AUTOSAVE_IDLE)
+	}
+}
+
+func TestParseConfigBackup(t *testing.T) {
+	backup := BACKUP
+	defer func() { BACKUP = backup }()
+
+	parseConfig("backup simple # Keep the previous version of a file when saving over it\n")
+	if BACKUP != "simple" {
+		t.Errorf("backup %q, want simple", BACKUP)
+	}
+}
+
+func TestParseConfigClipboard(t *testing.T) {
+	clipboard, copy, paste := CLIPBOARD, CLIPBOARD_COPY, CLIPBOARD_PASTE
+	defer func() { CLIPBOARD, CLIPBOARD_COPY, CLIPBOARD_PASTE = clipboard, copy, paste }()
+
+	parseConfig(`clipboard command                       # Where copied text goes
+clipboard_copy  "wl-copy"               # Command that gets the copied text on its input
+clipboard_paste "wl-paste --no-newline" # Command that prints the text to paste
+`)
+	if CLIPBOARD != "command" || CLIPBOARD_COPY != "wl-copy" || CLIPBOARD_PASTE != "wl-paste --no-newline" {
+		t.Errorf("clipboard %q, copy %q, paste %q", CLIPBOARD, CLIPBOARD_COPY, CLIPBOARD_PASTE)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	. "strings"
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	KeywordToken TokenKind = iota
+	TypeToken
+	StringToken
+	NumberToken
+	CommentToken
+)
+
+// Color returns the color a token is drawn with, only the foreground is
+// set so the background of the code block stays
+func (kind TokenKind) Color() string {
+	return []string{KEYWORD_FG, TYPE_FG, STRING_FG, NUMBER_FG, COMMENT_FG}[kind]
+}
+
+// Region is a token that can go on over several lines, like a block comment
+// or a raw string
+type Region struct {
+	Open  string
+	Close string
+	Kind  TokenKind
+}
+
+// Syntax is what the highlighter knows about a language, it only tells
+// words, strings, numbers and comments apart which is enough for notes
+type Syntax struct {
+	Keywords map[string]bool
+	Types    map[string]bool
+	Comment  string
+	Quotes   string
+	Regions  []Region
+}
+
+func words(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range Fields(s) {
+		set[word] = true
+	}
+	return set
+}
+
+var (
+	goSyntax = &Syntax{
+		Keywords: words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var true false nil iota"),
+		Types:    words("any bool byte comparable complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr"),
+		Comment:  "//",
+		Quotes:   `"'`,
+		Regions:  []Region{{"/*", "*/", CommentToken}, {"`", "`", StringToken}},
+	}
+	shellSyntax = &Syntax{
+		Keywords: words("if then else elif fi case esac for while until do done in function return local export readonly declare select time"),
+		Types:    words("echo printf cd exit set unset source read eval exec test shift trap alias sudo"),
+		Comment:  "#",
+		Quotes:   `"'`,
+	}
+	jsonSyntax = &Syntax{
+		Keywords: words("true false null"),
+		Quotes:   `"`,
+	}
+	pythonSyntax = &Syntax{
+		Keywords: words("False None True and as assert async await break class continue def del elif else except finally for from global if import in is lambda match nonlocal not or pass raise return try while with yield self"),
+		Types:    words("bool bytes dict float int list object set str tuple"),
+		Comment:  "#",
+		Quotes:   `"'`,
+		Regions:  []Region{{`"""`, `"""`, StringToken}, {"'''", "'''", StringToken}},
+	}
+	javascriptSyntax = &Syntax{
+		Keywords: words("async await break case catch class const continue debugger default delete do else enum export extends finally for from function if implements import in instanceof interface let new of return static super switch this throw try type typeof var void while with yield true false null undefined"),
+		Types:    words("any boolean never number object string symbol unknown Array Map Object Promise Set"),
+		Comment:  "//",
+		Quotes:   `"'`,
+		Regions:  []Region{{"/*", "*/", CommentToken}, {"`", "`", StringToken}},
+	}
+	yamlSyntax = &Syntax{
+		Keywords: words("true false null yes no on off"),
+		Comment:  "#",
+		Quotes:   `"'`,
+	}
+)
+
+// syntaxes maps the language of a fenced code block to its syntax, the names
+// are matched without case
+var syntaxes = map[string]*Syntax{
+	"go":         goSyntax,
+	"golang":     goSyntax,
+	"sh":         shellSyntax,
+	"bash":       shellSyntax,
+	"zsh":        shellSyntax,
+	"shell":      shellSyntax,
+	"console":    shellSyntax,
+	"json":       jsonSyntax,
+	"py":         pythonSyntax,
+	"python":     pythonSyntax,
+	"js":         javascriptSyntax,
+	"javascript": javascriptSyntax,
+	"ts":         javascriptSyntax,
+	"typescript": javascriptSyntax,
+	"yaml":       yamlSyntax,
+	"yml":        yamlSyntax,
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func hasPrefixAt(runes []rune, i int, prefix string) bool {
+	return prefix != "" && HasPrefix(string(runes[i:]), prefix)
+}
+
+// indexFrom returns where s is found in runes at or after from, or -1
+func indexFrom(runes []rune, from int, s string) int {
+	for i := from; i < len(runes); i++ {
+		if hasPrefixAt(runes, i, s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// highlight returns the colored tokens of every line of a code block, the
+// lines are highlighted together so comments and strings can span them
+func (s *Syntax) highlight(lines []string) [][]Span {
+	spans := make([][]Span, len(lines))
+	// region is the one the previous line ended in, or nil
+	var region *Region
+
+	for n, line := range lines {
+		runes := []rune(line)
+		add := func(start, end int, kind TokenKind) {
+			spans[n] = append(spans[n], Span{start, end, kind.Color()})
+		}
+		// closeRegion ends the open region from start and returns where the
+		// text after it starts
+		closeRegion := func(start, from int) int {
+			end := indexFrom(runes, from, region.Close)
+			if end < 0 {
+				add(start, len(runes), region.Kind)
+				return len(runes)
+			}
+			end += len([]rune(region.Close))
+			add(start, end, region.Kind)
+			region = nil
+			return end
+		}
+
+		i := 0
+		if region != nil {
+			i = closeRegion(0, 0)
+		}
+	scan:
+		for i < len(runes) {
+			c := runes[i]
+			for j := range s.Regions {
+				if hasPrefixAt(runes, i, s.Regions[j].Open) {
+					region = &s.Regions[j]
+					i = closeRegion(i, i+len([]rune(region.Open)))
+					continue scan
+				}
+			}
+
+			switch {
+			case hasPrefixAt(runes, i, s.Comment) && (s.Comment != "#" || i == 0 || unicode.IsSpace(runes[i-1])):
+				add(i, len(runes), CommentToken)
+				break scan
+
+			case ContainsRune(s.Quotes, c):
+				j := i + 1
+				for j < len(runes) && runes[j] != c {
+					if runes[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				end := min(j+1, len(runes))
+				add(i, end, StringToken)
+				i = end
+
+			case unicode.IsDigit(c) && (i == 0 || !isIdent(runes[i-1])):
+				j := i
+				for j < len(runes) && (isIdent(runes[j]) || runes[j] == '.') {
+					j++
+				}
+				add(i, j, NumberToken)
+				i = j
+
+			case isIdent(c):
+				j := i
+				for j < len(runes) && isIdent(runes[j]) {
+					j++
+				}
+				if word := string(runes[i:j]); s.Keywords[word] {
+					add(i, j, KeywordToken)
+				} else if s.Types[word] {
+					add(i, j, TypeToken)
+				}
+				i = j
+
+			default:
+				i++
+			}
+		}
+	}
+	return spans
+}
@@ -0,0 +1,113 @@
+package main
+
+type Cursor struct {
+	Row int
+	Col int
+	Pos int
+}
+
+type Edit struct {
+	Pos      int
+	Removed  string
+	Inserted string
+}
+
+type Change struct {
+	ID     int
+	Kind   string
+	Edits  []Edit
+	Before Cursor
+	After  Cursor
+}
+
+type History struct {
+	undo []Change
+	redo []Change
+	open bool
+	next int
+}
+
+// Record adds an edit to the history, edits of the same kind made right
+// after each other (typing a word, holding backspace) are merged into one step
+func (h *History) Record(kind string, edit Edit, before, after Cursor) {
+	h.redo = nil
+
+	if h.open && kind != "" && len(h.undo) > 0 {
+		last := &h.undo[len(h.undo)-1]
+		prev := last.Edits[len(last.Edits)-1]
+		if last.Kind == kind && (edit.Pos == prev.Pos+length(prev.Inserted) || edit.Pos+length(edit.Removed) == prev.Pos) {
+			last.Edits = append(last.Edits, edit)
+			last.After = after
+			h.next++
+			last.ID = h.next
+			return
+		}
+	}
+
+	h.next++
+	h.undo = append(h.undo, Change{ID: h.next, Kind: kind, Edits: []Edit{edit}, Before: before, After: after})
+	h.open = kind != ""
+}
+
+// RecordGroup adds edits that were already made one after another as a
+// single step, so undo takes all of them back at once
+func (h *History) RecordGroup(edits []Edit, before, after Cursor) {
+	if len(edits) == 0 {
+		return
+	}
+	h.redo = nil
+	h.next++
+	h.undo = append(h.undo, Change{ID: h.next, Edits: edits, Before: before, After: after})
+	h.open = false
+}
+
+// Version identifies the current state of the buffer, it is the same after
+// undoing back to a state so it can tell whether the note was modified
+func (h *History) Version() int {
+	if len(h.undo) == 0 {
+		return 0
+	}
+	return h.undo[len(h.undo)-1].ID
+}
+
+// Break stops the next edit from being merged into the previous step
+func (h *History) Break() {
+	h.open = false
+}
+
+func (h *History) Undo(buffer *Buffer) bool {
+	h.open = false
+	if len(h.undo) == 0 {
+		return false
+	}
+
+	change := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	for i := len(change.Edits) - 1; i >= 0; i-- {
+		edit := change.Edits[i]
+		buffer.Delete(edit.Pos, length(edit.Inserted))
+		buffer.Insert(edit.Pos, edit.Removed)
+	}
+	buffer.SetCursor(change.Before.Row, change.Before.Col)
+	h.redo = append(h.redo, change)
+
+	return true
+}
+
+func (h *History) Redo(buffer *Buffer) bool {
+	h.open = false
+	if len(h.redo) == 0 {
+		return false
+	}
+
+	change := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	for _, edit := range change.Edits {
+		buffer.Delete(edit.Pos, length(edit.Removed))
+		buffer.Insert(edit.Pos, edit.Inserted)
+	}
+	buffer.SetCursor(change.After.Row, change.After.Col)
+	h.undo = append(h.undo, change)
+
+	return true
+}
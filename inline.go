@@ -0,0 +1,231 @@
+package main
+
+import (
+	. "strings"
+	"unicode"
+)
+
+// Style is a set of inline markdown styles applied to a rune in preview mode
+type Style uint8
+
+const (
+	Bold Style = 1 << iota
+	Italic
+	Strike
+	Underline
+	Code
+)
+
+// SGR returns the escape sequence that turns on every style in s
+func (s Style) SGR() string {
+	var sgr string
+	if s&Code != 0 {
+		sgr += CODE
+	}
+	if s&Bold != 0 {
+		sgr += "\x1b[1m"
+	}
+	if s&Italic != 0 {
+		sgr += "\x1b[3m"
+	}
+	if s&Underline != 0 {
+		sgr += "\x1b[4m"
+	}
+	if s&Strike != 0 {
+		sgr += "\x1b[9m"
+	}
+	return sgr
+}
+
+// inlineItem is either text or a delimiter, open and close mark delimiters
+// that found their pair, any other delimiter is shown as the text it was
+type inlineItem struct {
+	text  []rune
+	style Style
+
+	kind  Style
+	open  bool
+	close bool
+}
+
+func isPunct(r rune) bool {
+	return r <= unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSymbol(r))
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parseInline splits a line of markdown into the runes that are shown and
+// the style of each of them
+//
+// Delimiters are matched with a stack the way markdown does it, a closing
+// delimiter closes the nearest opener of the same kind and openers between
+// them that were never closed are shown as plain text, so "**a *b** c" is
+// a bold "a *b" followed by " c"
+func parseInline(text []rune) ([]rune, []Style) {
+	var items []inlineItem
+	var stack []int
+
+	delimiter := func(kind Style, marker string, canOpen, canClose bool) {
+		if canClose {
+			for j := len(stack) - 1; j >= 0; j-- {
+				opener := &items[stack[j]]
+				if opener.kind == kind && opener.text[0] == rune(marker[0]) {
+					opener.open = true
+					items = append(items, inlineItem{text: []rune(marker), kind: kind, close: true})
+					stack = stack[:j]
+					return
+				}
+			}
+		}
+		items = append(items, inlineItem{text: []rune(marker), kind: kind})
+		if canOpen {
+			stack = append(stack, len(items)-1)
+		}
+	}
+	literal := func(runes []rune, style Style) {
+		items = append(items, inlineItem{text: runes, style: style})
+	}
+
+	run := func(i int) int {
+		j := i
+		for j < len(text) && text[j] == text[i] {
+			j++
+		}
+		return j - i
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		prev, next := ' ', ' '
+		if i > 0 {
+			prev = text[i-1]
+		}
+
+		switch {
+		case c == '\\' && i+1 < len(text) && isPunct(text[i+1]):
+			literal(text[i+1:i+2], 0)
+			i += 2
+
+		case c == '`':
+			n := run(i)
+			end := -1
+			for j := i + n; j < len(text); {
+				if text[j] == '`' {
+					if m := run(j); m == n {
+						end = j
+						break
+					} else {
+						j += m
+					}
+				} else {
+					j++
+				}
+			}
+			if end < 0 {
+				literal(text[i:i+n], 0)
+				i += n
+				break
+			}
+			code := text[i+n : end]
+			if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+			literal(code, Code)
+			i = end + n
+
+		case c == '*' || c == '_':
+			n := run(i)
+			if i+n < len(text) {
+				next = text[i+n]
+			}
+			canOpen := !unicode.IsSpace(next)
+			canClose := !unicode.IsSpace(prev)
+			if c == '_' {
+				// snake_case_words are not italic
+				canOpen = canOpen && !isAlnum(prev)
+				canClose = canClose && !isAlnum(next)
+			}
+			marker := string(c)
+
+			switch n {
+			case 1:
+				delimiter(Italic, marker, canOpen, canClose)
+			case 2:
+				delimiter(Bold, marker+marker, canOpen, canClose)
+			default:
+				// a closing *** ends the italic that was opened last
+				italicOpen := false
+				for _, j := range stack {
+					italicOpen = italicOpen || items[j].kind == Italic && items[j].text[0] == c
+				}
+				if canClose && italicOpen {
+					delimiter(Italic, marker, canOpen, canClose)
+					delimiter(Bold, marker+marker, canOpen, canClose)
+				} else {
+					delimiter(Bold, marker+marker, canOpen, canClose)
+					delimiter(Italic, marker, canOpen, canClose)
+				}
+				if n > 3 {
+					literal(text[i+3:i+n], 0)
+				}
+			}
+			i += n
+
+		case c == '~' && run(i) == 2:
+			if i+2 < len(text) {
+				next = text[i+2]
+			}
+			delimiter(Strike, "~~", !unicode.IsSpace(next), !unicode.IsSpace(prev))
+			i += 2
+
+		case c == '<' && HasPrefix(ToLower(string(text[i:min(i+3, len(text))])), "<u>"):
+			delimiter(Underline, "<u>", true, false)
+			i += 3
+
+		case c == '<' && HasPrefix(ToLower(string(text[i:min(i+4, len(text))])), "</u>"):
+			delimiter(Underline, "</u>", false, true)
+			i += 4
+
+		default:
+			literal(text[i:i+1], 0)
+			i++
+		}
+	}
+
+	var runes []rune
+	var styles []Style
+	var style Style
+	for _, item := range items {
+		if item.open {
+			style |= item.kind
+		} else if item.close {
+			style &^= item.kind
+		} else {
+			for _, r := range item.text {
+				runes = append(runes, r)
+				styles = append(styles, style|item.style)
+			}
+		}
+	}
+	return runes, styles
+}
+
+// paintStyles renders the part of a line shown on row with the inline styles
+// of every rune, base is the color of the line that styles are added to
+func paintStyles(runes []rune, styles []Style, row WrappedRow, base string) string {
+	var out Builder
+	var style Style
+	for i := row.Start; i < row.End; i++ {
+		if styles[i] != style {
+			out.WriteString("\x1b[0m" + base + styles[i].SGR())
+			style = styles[i]
+		}
+		out.WriteString(expandTabs(runes[i : i+1]))
+	}
+	if style != 0 {
+		out.WriteString("\x1b[0m" + base)
+	}
+	return out.String()
+}
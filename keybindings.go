@@ -0,0 +1,195 @@
+package main
+
+import (
+	. "fmt"
+	"os"
+	. "strings"
+)
+
+// ACTIONS lists every editor action a key can be bound to, "none" removes a
+// default binding
+var ACTIONS = []string{
+	"save", "save-as", "preview", "quit", "undo", "redo",
+	"search", "search-next", "search-prev", "replace",
+	"up", "down", "left", "right", "word-left", "word-right",
+	"line-start", "line-end", "note-start", "note-end",
+	"page-up", "page-down", "scroll-up", "scroll-down",
+	"select-up", "select-down", "select-left", "select-right",
+	"select-word-left", "select-word-right", "select-line-start", "select-line-end",
+	"select-note-start", "select-note-end", "select-page-up", "select-page-down",
+	"select-all", "copy", "cut", "paste",
+	"backspace", "delete", "newline", "indent", "overwrite", "format-table",
+	"none",
+}
+
+// MOVEMENTS are the actions that move the cursor, each of them can be used
+// with a "select-" prefix to extend the selection instead of dropping it
+var MOVEMENTS = []string{
+	"up", "down", "left", "right", "word-left", "word-right",
+	"line-start", "line-end", "note-start", "note-end", "page-up", "page-down",
+}
+
+var KEYBINDINGS = map[string]string{
+	"ctrl+s":      "save",
+	"alt+s":       "save-as",
+	"ctrl+p":      "preview",
+	"esc":         "quit",
+	"ctrl+z":      "undo",
+	"ctrl+y":      "redo",
+	"ctrl+f":      "search",
+	"ctrl+g":      "search-next",
+	"alt+g":       "search-prev",
+	"f3":          "search-next",
+	"shift+f3":    "search-prev",
+	"ctrl+r":      "replace",
+	"up":          "up",
+	"down":        "down",
+	"left":        "left",
+	"right":       "right",
+	"shift+up":    "select-up",
+	"shift+down":  "select-down",
+	"shift+left":  "select-left",
+	"shift+right": "select-right",
+	"ctrl+left":   "word-left",
+	"ctrl+right":  "word-right",
+	"alt+left":    "word-left",
+	"alt+right":   "word-right",
+	"home":        "line-start",
+	"end":         "line-end",
+	"ctrl+home":   "note-start",
+	"ctrl+end":    "note-end",
+	"pageup":      "page-up",
+	"pagedown":    "page-down",
+
+	"ctrl+shift+left":  "select-word-left",
+	"ctrl+shift+right": "select-word-right",
+	"shift+home":       "select-line-start",
+	"shift+end":        "select-line-end",
+	"ctrl+shift+home":  "select-note-start",
+	"ctrl+shift+end":   "select-note-end",
+	"shift+pageup":     "select-page-up",
+	"shift+pagedown":   "select-page-down",
+	"ctrl+a":           "select-all",
+	"ctrl+c":           "copy",
+	"ctrl+x":           "cut",
+	"ctrl+v":           "paste",
+
+	"ctrl+up":   "scroll-up",
+	"ctrl+down": "scroll-down",
+	"backspace": "backspace",
+	"delete":    "delete",
+	"enter":     "newline",
+	"tab":       "indent",
+	"insert":    "overwrite",
+	"alt+t":     "format-table",
+}
+
+var keyNames = []string{
+	"up", "down", "left", "right", "home", "end", "pageup", "pagedown",
+	"insert", "delete", "backspace", "enter", "tab", "esc", "space",
+	"f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "f9", "f10", "f11", "f12",
+}
+
+// sameAs are the control characters terminals also send for another key,
+// they always reach the editor as that key
+var sameAs = map[string]string{
+	"ctrl+h": "backspace", "ctrl+i": "tab", "ctrl+m": "enter",
+	"ctrl+alt+h": "alt+backspace", "ctrl+alt+i": "alt+tab", "ctrl+alt+m": "alt+enter",
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseChord checks a chord from the config and returns it in the same form
+// Key.String produces, with modifiers in ctrl, alt, shift order
+func parseChord(chord string) (string, error) {
+	parts := Split(chord, "+")
+	if HasSuffix(chord, "++") {
+		parts = append(parts[:len(parts)-2], "+")
+	}
+	name := parts[len(parts)-1]
+
+	key := Key{}
+	for _, mod := range parts[:len(parts)-1] {
+		switch ToLower(mod) {
+		case "ctrl":
+			key.Ctrl = true
+		case "alt":
+			key.Alt = true
+		case "shift":
+			key.Shift = true
+		default:
+			return "", Errorf("unknown modifier %s", mod)
+		}
+	}
+
+	if contains(keyNames, ToLower(name)) {
+		key.Name = ToLower(name)
+	} else if length(name) == 1 {
+		key.Name = name
+		if key.Ctrl {
+			key.Name = ToLower(name)
+		}
+	} else {
+		return "", Errorf("unknown key %s", name)
+	}
+
+	// some chords reach the editor as another key or not at all, binding
+	// them would never do anything
+	if other, ok := sameAs[key.String()]; ok {
+		return "", Errorf("%s is sent as %s by terminals", chord, other)
+	}
+	if key.Ctrl && length(key.Name) == 1 && !ContainsAny(key.Name, "abcdefghijklmnopqrstuvwxyz\\]^_") ||
+		key.Shift && (length(key.Name) == 1 || contains([]string{"backspace", "enter", "esc", "space"}, key.Name)) ||
+		key.Name == "space" && !key.Ctrl {
+		return "", Errorf("%s is not sent by terminals", chord)
+	}
+	return key.String(), nil
+}
+
+// parseKeybindings reads lines of "<chord> <action>" and applies them on top
+// of the default bindings, a chord can only be bound once per file
+func parseKeybindings(contents string) {
+	bound := map[string]string{}
+	for n, line := range Split(contents, "\n") {
+		fields := Fields(line)
+		if len(fields) == 0 || HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 && HasPrefix(fields[2], "#") {
+			fields = fields[:2]
+		}
+		if len(fields) != 2 {
+			Printf("Invalid keybinding on line %d: %s\n", n+1, TrimSpace(line))
+			os.Exit(1)
+		}
+
+		chord, err := parseChord(fields[0])
+		if err != nil {
+			Printf("Invalid keybinding on line %d: %s\n", n+1, err)
+			os.Exit(1)
+		}
+		action := fields[1]
+		if !contains(ACTIONS, action) {
+			Printf("Unknown action in keybindings on line %d: %s\n", n+1, action)
+			os.Exit(1)
+		}
+		if other, ok := bound[chord]; ok && other != action {
+			Printf("Conflicting keybindings for %s: %s and %s\n", chord, other, action)
+			os.Exit(1)
+		}
+		bound[chord] = action
+
+		if action == "none" {
+			delete(KEYBINDINGS, chord)
+		} else {
+			KEYBINDINGS[chord] = action
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseChord(t *testing.T) {
+	tests := []struct {
+		chord string
+		want  string
+	}{
+		{"ctrl+s", "ctrl+s"},
+		{"Ctrl+S", "ctrl+s"},
+		{"shift+ctrl+left", "ctrl+shift+left"},
+		{"alt+A", "alt+A"},
+		{"ctrl++", ""},
+		{"alt++", "alt++"},
+		{"ctrl+space", "ctrl+space"},
+		{"ctrl+]", "ctrl+]"},
+		{"ctrl+j", "ctrl+j"},
+		{"shift+tab", "shift+tab"},
+		{"shift+f3", "shift+f3"},
+		{"F5", "f5"},
+		// chords the terminal never sends are rejected
+		{"ctrl+h", ""},
+		{"ctrl+i", ""},
+		{"ctrl+m", ""},
+		{"ctrl+alt+m", ""},
+		{"ctrl+1", ""},
+		{"shift+a", ""},
+		{"shift+enter", ""},
+		{"space", ""},
+		{"alt+space", ""},
+		{"super+a", ""},
+		{"foo", ""},
+	}
+	for _, test := range tests {
+		got, err := parseChord(test.chord)
+		if test.want == "" && err == nil {
+			t.Errorf("parseChord(%q) = %q, want an error", test.chord, got)
+		} else if test.want != "" && (err != nil || got != test.want) {
+			t.Errorf("parseChord(%q) = %q, %v, want %q", test.chord, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"os"
+	. "strconv"
+	. "strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Key struct {
+	Name  string
+	Ctrl  bool
+	Alt   bool
+	Shift bool
+
+	// X and Y are the 1-based cell of a mouse event
+	X int
+	Y int
+
+	// Text is what was pasted for a paste event
+	Text string
+}
+
+// String returns the key as a chord like "ctrl+shift+left", plain keys are
+// returned as their name so typed characters stay the characters themselves
+func (k Key) String() string {
+	var chord string
+	if k.Ctrl {
+		chord += "ctrl+"
+	}
+	if k.Alt {
+		chord += "alt+"
+	}
+	if k.Shift {
+		chord += "shift+"
+	}
+	return chord + k.Name
+}
+
+func (k Key) IsMouse() bool {
+	return k.X > 0
+}
+
+func (k Key) IsChar() bool {
+	if k.Ctrl || k.Alt {
+		return false
+	}
+	r, size := utf8.DecodeRuneInString(k.Name)
+	if size != len(k.Name) || r == utf8.RuneError {
+		return false
+	}
+	return unicode.IsPrint(r)
+}
+
+// modifiers decodes the xterm modifier parameter, which is one more than a
+// bit mask of shift (1), alt (2) and ctrl (4)
+func modifiers(key Key, param string) Key {
+	m, err := Atoi(param)
+	if err != nil || m < 2 {
+		return key
+	}
+	m--
+	key.Shift = m&1 != 0
+	key.Alt = m&2 != 0
+	key.Ctrl = m&4 != 0
+	return key
+}
+
+var tildeKeys = map[string]string{
+	"1": "home", "2": "insert", "3": "delete", "4": "end", "5": "pageup", "6": "pagedown",
+	"7": "home", "8": "end", "11": "f1", "12": "f2", "13": "f3", "14": "f4", "15": "f5",
+	"17": "f6", "18": "f7", "19": "f8", "20": "f9", "21": "f10", "23": "f11", "24": "f12",
+}
+
+var letterKeys = map[byte]string{
+	'A': "up", 'B': "down", 'C': "right", 'D': "left", 'H': "home", 'F': "end",
+	'P': "f1", 'Q': "f2", 'R': "f3", 'S': "f4",
+}
+
+var mouseButtons = map[int]string{
+	0: "click", 1: "middleclick", 2: "rightclick", 64: "wheelup", 65: "wheeldown",
+}
+
+// parseMouse decodes an SGR mouse report "ESC [ < button ; x ; y M", the
+// final byte is m instead of M when a button is released
+func parseMouse(params []string, final byte) Key {
+	if len(params) != 3 {
+		return Key{}
+	}
+	b, err1 := Atoi(params[0])
+	x, err2 := Atoi(params[1])
+	y, err3 := Atoi(params[2])
+	// cells are counted from 1, a report outside the screen is dropped
+	if err1 != nil || err2 != nil || err3 != nil || x < 1 || y < 1 {
+		return Key{}
+	}
+
+	key := Key{X: x, Y: y, Shift: b&4 != 0, Alt: b&8 != 0, Ctrl: b&16 != 0}
+	button := b &^ (4 | 8 | 16 | 32)
+	if final == 'm' {
+		key.Name = "release"
+	} else if b&32 != 0 {
+		key.Name = "drag"
+	} else if name, ok := mouseButtons[button]; ok {
+		key.Name = name
+	} else {
+		return Key{}
+	}
+	return key
+}
+
+// parseCSI decodes "ESC [ params final", n is 0 when the sequence is not
+// complete yet
+func parseCSI(b []byte) (Key, int) {
+	i := 2
+	for i < len(b) && b[i] >= 0x20 && b[i] <= 0x3f {
+		i++
+	}
+	if i >= len(b) {
+		return Key{}, 0
+	}
+	final := b[i]
+	params := Split(string(b[2:i]), ";")
+	n := i + 1
+
+	if HasPrefix(params[0], "<") && (final == 'M' || final == 'm') {
+		params[0] = params[0][1:]
+		return parseMouse(params, final), n
+	}
+
+	if final == '~' {
+		name, ok := tildeKeys[params[0]]
+		if !ok {
+			return Key{}, n
+		}
+		key := Key{Name: name}
+		if len(params) > 1 {
+			key = modifiers(key, params[1])
+		}
+		return key, n
+	}
+	if final == 'Z' {
+		return Key{Name: "tab", Shift: true}, n
+	}
+	if name, ok := letterKeys[final]; ok {
+		key := Key{Name: name}
+		if len(params) > 1 {
+			key = modifiers(key, params[1])
+		}
+		return key, n
+	}
+	return Key{}, n
+}
+
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// parsePaste collects everything between the bracketed paste markers into a
+// single key, it keeps waiting for the end marker even after a timeout since
+// big pastes can arrive in several chunks
+func parsePaste(b []byte) (Key, int) {
+	end := Index(string(b), pasteEnd)
+	if end < 0 {
+		return Key{}, 0
+	}
+	text := string(b[len(pasteStart):end])
+	text = ReplaceAll(text, "\r\n", "\n")
+	text = ReplaceAll(text, "\r", "\n")
+	return Key{Name: "paste", Text: text}, end + len(pasteEnd)
+}
+
+// parseKey turns the start of b into a key and returns how many bytes it
+// used, 0 means more bytes are needed, when final is set no more bytes are
+// coming and whatever is there has to be decoded
+func parseKey(b []byte, final bool) (Key, int) {
+	c := b[0]
+	if c == KeyEscape {
+		if len(b) == 1 {
+			if final {
+				return Key{Name: "esc"}, 1
+			}
+			return Key{}, 0
+		}
+		switch b[1] {
+		case '[':
+			if HasPrefix(string(b), pasteStart) {
+				return parsePaste(b)
+			}
+			key, n := parseCSI(b)
+			if n == 0 && final {
+				return Key{}, len(b)
+			}
+			return key, n
+		case 'O':
+			if len(b) < 3 {
+				if final {
+					return Key{Name: "O", Alt: true}, 2
+				}
+				return Key{}, 0
+			}
+			if name, ok := letterKeys[b[2]]; ok {
+				return Key{Name: name}, 3
+			}
+			return Key{}, 3
+		case KeyEscape:
+			return Key{Name: "esc"}, 1
+		}
+		key, n := parseKey(b[1:], final)
+		if n == 0 {
+			return key, 0
+		}
+		key.Alt = true
+		return key, n + 1
+	}
+
+	if c < utf8.RuneSelf {
+		switch c {
+		case KeyBackspace, '\x7f':
+			return Key{Name: "backspace"}, 1
+		case KeyEnter:
+			return Key{Name: "enter"}, 1
+		case Tab:
+			return Key{Name: "tab"}, 1
+		case 0:
+			return Key{Name: "space", Ctrl: true}, 1
+		}
+		if c <= 26 {
+			return Key{Name: string(rune('a' + c - 1)), Ctrl: true}, 1
+		}
+		if c < ' ' {
+			return Key{Name: string(rune('\\' + c - 28)), Ctrl: true}, 1
+		}
+		return Key{Name: string(c)}, 1
+	}
+
+	if !utf8.FullRune(b) {
+		if final {
+			return Key{}, 1
+		}
+		return Key{}, 0
+	}
+	r, size := utf8.DecodeRune(b)
+	if r == utf8.RuneError {
+		return Key{}, size
+	}
+	return Key{Name: string(r)}, size
+}
+
+// resized is notified of SIGWINCH, readKey reports it as a "resize" key so
+// the editor redraws right away instead of on the next key press
+var resized = make(chan os.Signal, 1)
+
+// input holds bytes read from the terminal that were not turned into a key
+// yet, one read can contain several keys when typing fast or pasting
+var input []byte
+
+// readKey returns the next key, or an empty key when nothing was pressed
+// within the VTIME timeout set in setRawTerminal
+//
+// A lone escape byte is only reported as esc once the timeout passes without
+// the rest of an escape sequence arriving
+func readKey() (Key, error) {
+	var buf [64]byte
+	final := false
+	for {
+		for len(input) > 0 {
+			key, n := parseKey(input, final)
+			if n == 0 {
+				break
+			}
+			input = input[n:]
+			if key.Name != "" {
+				return key, nil
+			}
+		}
+		if final {
+			return Key{}, nil
+		}
+
+		select {
+		case <-resized:
+			return Key{Name: "resize"}, nil
+		default:
+		}
+
+		n, err := os.Stdin.Read(buf[:])
+		if err != nil {
+			if err.Error() == "EOF" {
+				final = true
+				continue
+			}
+			return Key{}, err
+		}
+		input = append(input, buf[:n]...)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Key
+		n     int
+	}{
+		{"a", Key{Name: "a"}, 1},
+		{"é", Key{Name: "é"}, 2},
+		{"\x01", Key{Name: "a", Ctrl: true}, 1},
+		{"\x00", Key{Name: "space", Ctrl: true}, 1},
+		{"\x08", Key{Name: "backspace"}, 1},
+		{"\x7f", Key{Name: "backspace"}, 1},
+		{"\r", Key{Name: "enter"}, 1},
+		{"\t", Key{Name: "tab"}, 1},
+		{"\x1bx", Key{Name: "x", Alt: true}, 2},
+		{"\x1b[A", Key{Name: "up"}, 3},
+		{"\x1b[1;5C", Key{Name: "right", Ctrl: true}, 6},
+		{"\x1b[1;2D", Key{Name: "left", Shift: true}, 6},
+		{"\x1b[3~", Key{Name: "delete"}, 4},
+		{"\x1b[Z", Key{Name: "tab", Shift: true}, 3},
+		{"\x1bOH", Key{Name: "home"}, 3},
+		{"\x1b[200~a\rb\x1b[201~", Key{Name: "paste", Text: "a\nb"}, 15},
+		{"\x1b[<0;3;2M", Key{Name: "click", X: 3, Y: 2}, 9},
+		{"\x1b[<32;3;2M", Key{Name: "drag", X: 3, Y: 2}, 10},
+		{"\x1b[<65;1;1M", Key{Name: "wheeldown", X: 1, Y: 1}, 10},
+		{"\x1b[<0;3;2m", Key{Name: "release", X: 3, Y: 2}, 9},
+		// reports outside the screen are dropped
+		{"\x1b[<0;3;0M", Key{}, 9},
+		{"\x1b[<0;0;3M", Key{}, 9},
+	}
+	for _, test := range tests {
+		key, n := parseKey([]byte(test.input), true)
+		if key != test.want || n != test.n {
+			t.Errorf("parseKey(%q) = %+v, %d, want %+v, %d", test.input, key, n, test.want, test.n)
+		}
+	}
+}
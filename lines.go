@@ -0,0 +1,170 @@
+package main
+
+import "math/rand"
+
+// lineNode is a node of the tree the lines of a note are kept in, the tree is
+// ordered by line number and balanced by random priorities (a treap), so
+// every operation takes O(log n) steps on average
+type lineNode struct {
+	line        []rune
+	left, right *lineNode
+	priority    uint32
+
+	// count is how many lines the subtree holds and size how many runes,
+	// every line is counted with the newline after it
+	count int
+	size  int
+}
+
+func newLineNode(line []rune) *lineNode {
+	n := &lineNode{line: line, priority: rand.Uint32()}
+	return n.update()
+}
+
+func (n *lineNode) lines() int {
+	if n == nil {
+		return 0
+	}
+	return n.count
+}
+
+func (n *lineNode) runes() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *lineNode) update() *lineNode {
+	n.count = n.left.lines() + 1 + n.right.lines()
+	n.size = n.left.runes() + len(n.line) + 1 + n.right.runes()
+	return n
+}
+
+// mergeLines joins two trees, the lines of a come before those of b
+func mergeLines(a, b *lineNode) *lineNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = mergeLines(a.right, b)
+		return a.update()
+	}
+	b.left = mergeLines(a, b.left)
+	return b.update()
+}
+
+// splitLines cuts a tree into its first k lines and the rest
+func splitLines(n *lineNode, k int) (*lineNode, *lineNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if k <= n.left.lines() {
+		left, right := splitLines(n.left, k)
+		n.left = right
+		return left, n.update()
+	}
+	left, right := splitLines(n.right, k-n.left.lines()-1)
+	n.right = left
+	return n.update(), right
+}
+
+// lineTree holds the lines of a note, there is always at least one
+type lineTree struct {
+	root *lineNode
+}
+
+func newLineTree(lines [][]rune) lineTree {
+	var l lineTree
+	l.Replace(0, 0, lines)
+	return l
+}
+
+// Len returns how many lines there are
+func (l *lineTree) Len() int {
+	return l.root.lines()
+}
+
+// Runes returns how many runes there are, counting the newlines between
+// lines
+func (l *lineTree) Runes() int {
+	return l.root.runes() - 1
+}
+
+// At returns the line at row
+func (l *lineTree) At(row int) []rune {
+	n := l.root
+	for {
+		if row < n.left.lines() {
+			n = n.left
+		} else if row == n.left.lines() {
+			return n.line
+		} else {
+			row -= n.left.lines() + 1
+			n = n.right
+		}
+	}
+}
+
+// Start returns the offset the line at row starts at
+func (l *lineTree) Start(row int) int {
+	start := 0
+	for n := l.root; n != nil; {
+		if row < n.left.lines() {
+			n = n.left
+		} else if row == n.left.lines() {
+			return start + n.left.runes()
+		} else {
+			row -= n.left.lines() + 1
+			start += n.left.runes() + len(n.line) + 1
+			n = n.right
+		}
+	}
+	return start
+}
+
+// Find returns the row offset falls on and the offset that row starts at,
+// offsets past the end fall on the last line
+func (l *lineTree) Find(offset int) (int, int) {
+	row, start := 0, 0
+	for n := l.root; n != nil; {
+		if offset < start+n.left.runes() {
+			n = n.left
+			continue
+		}
+		end := start + n.left.runes() + len(n.line)
+		if offset <= end || n.right == nil {
+			return row + n.left.lines(), start + n.left.runes()
+		}
+		row += n.left.lines() + 1
+		start = end + 1
+		n = n.right
+	}
+	return row, start
+}
+
+// Replace puts lines in place of the n lines starting at row
+func (l *lineTree) Replace(row, n int, lines [][]rune) {
+	before, rest := splitLines(l.root, row)
+	_, after := splitLines(rest, n)
+	for _, line := range lines {
+		before = mergeLines(before, newLineNode(line))
+	}
+	l.root = mergeLines(before, after)
+}
+
+// Each calls f with every line from the first to the last
+func (l *lineTree) Each(f func(line []rune)) {
+	var walk func(n *lineNode)
+	walk = func(n *lineNode) {
+		if n != nil {
+			walk(n.left)
+			f(n.line)
+			walk(n.right)
+		}
+	}
+	walk(l.root)
+}
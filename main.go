@@ -3,9 +3,13 @@ package main
 import (
 	. "fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	. "strconv"
 	. "strings"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -24,18 +28,43 @@ var (
 	NERD_FONT = false
 	UNICODE   = false
 
-	LINE_NUM_FG      = "\x1b[38;5;239m"
-	LINE_NUM_BG      = "\x1b[48;5;234m"
-	TEXT_FG          = "\x1b[38;5;15m"
-	TEXT_BG          = "\x1b[48;5;234m"
-	EMPTY_LINE_FG    = "\x1b[38;5;236m"
-	EMPTY_LINE_BG    = "\x1b[48;5;234m"
-	STATUS_LINE_FG   = "\x1b[38;5;15m"
-	STATUS_LINE_BG   = "\x1b[48;5;234m"
-	SELECTED_NUM_FG  = "\x1b[38;5;11m"
-	SELECTED_NUM_BG  = "\x1b[48;5;239m"
-	SELECTED_TEXT_FG = "\x1b[38;5;11m"
-	SELECTED_TEXT_BG = "\x1b[48;5;239m"
+	// BACKUP is how the previous version of a file is kept when saving over
+	// it, "off", "simple" for file~ or "timestamp" for file.<time>~
+	BACKUP = "off"
+
+	// AUTOSAVE_IDLE saves a note that has a file this many seconds after the
+	// last change, 0 turns it off, AUTOSAVE_LINE saves when leaving a line
+	AUTOSAVE_IDLE = 0
+	AUTOSAVE_LINE = false
+
+	LINE_NUM_FG       = "\x1b[38;5;239m"
+	LINE_NUM_BG       = "\x1b[48;5;234m"
+	TEXT_FG           = "\x1b[38;5;15m"
+	TEXT_BG           = "\x1b[48;5;234m"
+	EMPTY_LINE_FG     = "\x1b[38;5;236m"
+	EMPTY_LINE_BG     = "\x1b[48;5;234m"
+	STATUS_LINE_FG    = "\x1b[38;5;15m"
+	STATUS_LINE_BG    = "\x1b[48;5;234m"
+	SELECTED_NUM_FG   = "\x1b[38;5;11m"
+	SELECTED_NUM_BG   = "\x1b[48;5;239m"
+	SELECTED_TEXT_FG  = "\x1b[38;5;11m"
+	SELECTED_TEXT_BG  = "\x1b[48;5;239m"
+	SEARCH_FG         = "\x1b[38;5;0m"
+	SEARCH_BG         = "\x1b[48;5;11m"
+	SEARCH_CURRENT_FG = "\x1b[38;5;0m"
+	SEARCH_CURRENT_BG = "\x1b[48;5;208m"
+	CODE_FG           = "\x1b[38;5;180m"
+	CODE_BG           = "\x1b[48;5;236m"
+	CODE_BLOCK_FG     = "\x1b[38;5;252m"
+	CODE_BLOCK_BG     = "\x1b[48;5;236m"
+	CODE_LABEL_FG     = "\x1b[38;5;246m"
+
+	// token colors of highlighted code blocks, they only set the foreground
+	KEYWORD_FG = "\x1b[38;5;204m"
+	TYPE_FG    = "\x1b[38;5;110m"
+	STRING_FG  = "\x1b[38;5;114m"
+	NUMBER_FG  = "\x1b[38;5;215m"
+	COMMENT_FG = "\x1b[38;5;243m"
 
 	H1 = "\x1b[38;5;14m"
 	H2 = "\x1b[38;5;13m"
@@ -44,14 +73,27 @@ var (
 	H5 = "\x1b[38;5;10m"
 	H6 = "\x1b[38;5;9m"
 
-	LINENUM      = LINE_NUM_FG + LINE_NUM_BG
-	LINETEXT     = TEXT_FG + TEXT_BG
-	EMPTYLINE    = EMPTY_LINE_FG + EMPTY_LINE_BG
-	STATUSLINE   = STATUS_LINE_FG + STATUS_LINE_BG
-	SELECTEDNUM  = SELECTED_NUM_FG + SELECTED_NUM_BG
-	SELECTEDTEXT = SELECTED_TEXT_FG + SELECTED_TEXT_BG
+	LINENUM       = LINE_NUM_FG + LINE_NUM_BG
+	LINETEXT      = TEXT_FG + TEXT_BG
+	EMPTYLINE     = EMPTY_LINE_FG + EMPTY_LINE_BG
+	STATUSLINE    = STATUS_LINE_FG + STATUS_LINE_BG
+	SELECTEDNUM   = SELECTED_NUM_FG + SELECTED_NUM_BG
+	SELECTEDTEXT  = SELECTED_TEXT_FG + SELECTED_TEXT_BG
+	SEARCH        = SEARCH_FG + SEARCH_BG
+	SEARCHCURRENT = SEARCH_CURRENT_FG + SEARCH_CURRENT_BG
+	CODE          = CODE_FG + CODE_BG
+	CODEBLOCK     = CODE_BLOCK_FG + CODE_BLOCK_BG
+	CODELABEL     = CODE_LABEL_FG + CODE_BLOCK_BG
 )
 
+// ScreenRow remembers which part of which line was drawn on a row of the
+// screen, so mouse clicks can be mapped back to the buffer
+type ScreenRow struct {
+	Line int
+	WrappedRow
+	Last bool
+}
+
 type Winsize struct {
 	Row    uint16
 	Col    uint16
@@ -60,7 +102,10 @@ type Winsize struct {
 }
 
 func restoreTerminal(oldState *syscall.Termios) {
+	Print("\x1b[?2004l")
+	Print("\x1b[?1006l\x1b[?1002l\x1b[?1000l")
 	Print("\x1b[?25h")
+	Print("\x1b[0m\x1b[?1049l")
 	if oldState != nil {
 		if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(syscall.Stdin), uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(oldState)), 0, 0, 0); err != 0 {
 			Println("Error restoring terminal:", err)
@@ -69,7 +114,13 @@ func restoreTerminal(oldState *syscall.Termios) {
 }
 
 func setRawTerminal() (*syscall.Termios, error) {
+	// the alternate screen keeps the shell scrollback untouched
+	Print("\x1b[?1049h")
 	Print("\x1b[?25l")
+	// report clicks, drags and the wheel as SGR mouse sequences
+	Print("\x1b[?1000h\x1b[?1002h\x1b[?1006h")
+	// pasted text is wrapped in markers so it can be inserted in one go
+	Print("\x1b[?2004h")
 	oldState := &syscall.Termios{}
 	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(syscall.Stdin), uintptr(syscall.TCGETS), uintptr(unsafe.Pointer(oldState)), 0, 0, 0); err != 0 {
 		return nil, err
@@ -91,64 +142,6 @@ func setRawTerminal() (*syscall.Termios, error) {
 	return oldState, nil
 }
 
-func readKey() (string, error) {
-	var buf [3]byte
-	for {
-		n, err := os.Stdin.Read(buf[:])
-		if err != nil {
-			if err.Error() == "EOF" {
-				return "", nil
-			}
-			return "", err
-		}
-
-		if n == 1 {
-			switch buf[0] {
-			case KeyBackspace, '\x7f':
-				return "backspace", nil
-			case KeyEscape:
-				return "esc", nil
-			case KeyEnter:
-				return "enter", nil
-			case Tab:
-				return "tab", nil
-			default:
-				return string(buf[0]), nil
-			}
-		} else if n == 3 && buf[0] == 27 && buf[1] == 91 {
-			switch buf[2] {
-			case 'A':
-				return "up", nil
-			case 'B':
-				return "down", nil
-			case 'C':
-				return "right", nil
-			case 'D':
-				return "left", nil
-			}
-		}
-	}
-}
-
-func isChar(key string) bool {
-	if len(key) != 1 {
-		return false
-	}
-	for _, c := range key {
-		if c < ' ' || c > '~' {
-			return false
-		}
-	}
-	return true
-}
-
-func isCtrl(key string, c byte) bool {
-	if len(key) != 1 {
-		return false
-	}
-	return key[0] == c-'a'+1
-}
-
 func getSize(fd int) (*Winsize, error) {
 	ws := &Winsize{}
 	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(ws)))
@@ -162,34 +155,229 @@ func length(s string) int {
 	return len([]rune(s))
 }
 
-func clearScreen() {
-	Print("\x1b[2J")
-	Print("\x1b[3J")
-	Print("\x1b[H")
-	os.Stdout.Sync()
-}
-
-func scratchPad(buffer string) error {
+// scratchPad runs the editor until it is closed, the returned message is
+// printed once the terminal is restored
+//
+// A recovered buffer starts out modified since its contents only exist in the
+// swap file so far
+func scratchPad(buffer *Buffer, path string, recovered bool) (string, error) {
 	var savePath string
+	var message string
+	prompt := Prompt{Paths: true}
+	var search Search
+	var searchFrom Cursor
+	var searchOffset int
+	var sub Substitution
+
+	// anchor is the other end of the selection from the cursor, -1 when
+	// nothing is selected
+	anchor := -1
+	var clipboard string
+	clipboardLines := false
 
 	saving := false
 	previewMode := false
+	// preview is the rendered note, it is only rendered again once the note
+	// changed since version previewed
+	var preview []PreviewLine
+	previewed := -1
+	quitting := false
+	confirming := false
+	question := ""
+	searching := false
+	replacing := ""
+	exitAfterSave := false
+	overwrite := false
 	offset := 0
-	pos2d := []int{0, 0}
 	cursorPos := []int{1, 4}
-	pos1d := 0
+	var screenRows []ScreenRow
+	numPadding := 1
+
+	var history History
+	var screen Screen
+	saved := history.Version()
+	disk := statFile(path)
+
+	autosave := AUTOSAVE_IDLE > 0 || AUTOSAVE_LINE
+	var savedAt time.Time
+	edited := history.Version()
+	editedAt := time.Now()
+	lastRow := buffer.Row
+
+	swap := swapPath(path)
+	swapped := history.Version()
+	swappedAt := time.Now()
+	if recovered {
+		saved = -1
+		message = "Recovered unsaved changes from the swap file"
+	}
+
+	// save writes the note to target and reports whether it did, it asks
+	// first when target is some other file or the file changed on disk since
+	// it was opened unless force is set, errors are shown in the status line
+	save := func(target string, force bool) (bool, error) {
+		if !force {
+			info, err := os.Stat(target)
+			current, _ := os.Stat(path)
+			same := target == path || (err == nil && current != nil && os.SameFile(info, current))
+			if same && statFile(path) != disk {
+				question = "File changed on disk since it was opened, overwrite it?"
+			} else if !same && err == nil && !info.IsDir() {
+				question = target + " already exists, overwrite it?"
+			}
+			if question != "" {
+				confirming = true
+				savePath = target
+				return false, nil
+			}
+		}
+		if err := saveFile(target, buffer.String()); err != nil {
+			message = saveError(target, err)
+			return false, err
+		}
+		os.Remove(swap)
+		path = target
+		disk = statFile(path)
+		saved = history.Version()
+		savedAt = time.Now()
+		swap = swapPath(path)
+		os.Remove(swap)
+		swapped = saved
+		autosave = AUTOSAVE_IDLE > 0 || AUTOSAVE_LINE
+		message = "Saved to " + path
+		return true, nil
+	}
+
+	// jump moves the cursor to a match and brings it to the middle of the
+	// screen when it is not visible yet
+	jump := func(i int, rows int) {
+		m := search.Matches[i]
+		buffer.SetCursor(m.Row, m.Start)
+		if m.Row < offset || m.Row >= offset+rows-1 {
+			offset = max(0, m.Row-(rows-1)/2)
+		}
+	}
+
+	// lineRows returns how many screen rows line is wrapped onto, for the
+	// line the cursor is on only the rows up to the cursor are counted, a
+	// cursor past the last cell gets a row of its own
+	lineRows := func(line int, ws *Winsize) int {
+		runes := []rune(buffer.Line(line))
+		cols := int(ws.Col) - numPadding - 2
+		rows := wrap(runes, cols)
+		if line != buffer.Row {
+			return len(rows)
+		}
+		for j, row := range rows {
+			if buffer.Col < row.End || j == len(rows)-1 {
+				if runesWidth(runes[row.Start:buffer.Col]) >= cols {
+					return j + 2
+				}
+				return j + 1
+			}
+		}
+		return len(rows)
+	}
+
+	// lastVisible returns the last line drawn completely when the screen
+	// starts at offset
+	lastVisible := func(ws *Winsize) int {
+		used := 0
+		for line := offset; line < buffer.LineCount(); line++ {
+			used += len(wrap([]rune(buffer.Line(line)), int(ws.Col)-numPadding-2))
+			if used > int(ws.Row)-1 {
+				return max(offset, line-1)
+			}
+		}
+		return buffer.LineCount() - 1
+	}
+
+	// selection returns the selected part of the note as offsets, start and
+	// end are the same when nothing is selected
+	selection := func() (int, int) {
+		if anchor < 0 {
+			return buffer.Pos(), buffer.Pos()
+		}
+		return min(anchor, buffer.Pos()), max(anchor, buffer.Pos())
+	}
+
+	// replaceSelection puts text in place of the selected text as one step
+	replaceSelection := func(text string) {
+		start, end := selection()
+		before := buffer.Cursor()
+		removed := buffer.Delete(start, end-start)
+		buffer.Insert(start, text)
+		buffer.MoveTo(start + length(text))
+		history.Record("", Edit{Pos: start, Removed: removed, Inserted: text}, before, buffer.Cursor())
+		anchor = -1
+	}
+
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
 
 	for {
 		var frame []string
 
 		ws, err := getSize(int(os.Stdout.Fd()))
 		if err != nil {
-			return err
+			return "", err
+		}
+		if ws.Row < 2 || ws.Col < 2 {
+			ws.Row, ws.Col = 24, 80
+		}
+
+		// the gutter is sized first, the rows lines wrap onto depend on it
+		numPadding = len(Sprint(buffer.LineCount() + offset))
+		if !previewMode {
+			// wrapped lines take several rows, so the lines above the cursor
+			// are counted until the screen is full
+			if buffer.Row < offset {
+				offset = buffer.Row
+			}
+			used := 0
+			for line := buffer.Row; line >= offset; line-- {
+				used += lineRows(line, ws)
+				if used > int(ws.Row)-1 {
+					offset = min(line+1, buffer.Row)
+					break
+				}
+			}
+		}
+
+		search.Find(buffer)
+		current := search.Current(buffer.Row, buffer.Col)
+
+		selectStart, selectEnd := selection()
+		selected := selectStart < selectEnd
+
+		// spans picks what is highlighted on a line, a selection hides the
+		// search matches and while confirming a replacement only the match
+		// in question is shown
+		spans := func(line int) []Span {
+			from, to := selectStart, selectEnd
+			color := SELECTEDTEXT
+			if !selected && replacing == "confirm" {
+				from, to = sub.Match[0], sub.Match[1]
+				color = SEARCHCURRENT
+			} else if !selected {
+				return search.Spans(line, current)
+			}
+			startRow, startCol := buffer.Position(from)
+			endRow, endCol := buffer.Position(to)
+			if line < startRow || line > endRow {
+				return nil
+			}
+			if line != startRow {
+				startCol = 0
+			}
+			if line != endRow {
+				endCol = buffer.LineLen(line)
+			}
+			return []Span{{startCol, endCol, color}}
 		}
 
 		intLines := 0
-		lines := Split(buffer, "\n")
-		numPadding := len(Sprint(len(lines) + offset))
+		screenRows = screenRows[:0]
 		var lineWrap string
 		if NERD_FONT {
 			lineWrap = "󱞩"
@@ -199,12 +387,22 @@ func scratchPad(buffer string) error {
 			lineWrap = ">"
 		}
 
-		for i, line := range lines[offset:] {
-			if intLines == int(ws.Row)-1 {
+		if previewMode {
+			if previewed != history.Version() {
+				preview = renderPreview(parseMarkdown(buffer.String()), buffer.LineCount())
+				previewed = history.Version()
+			}
+			frame = drawPreview(preview, offset, int(ws.Col), int(ws.Row)-1, numPadding, lineWrap)
+			intLines = len(frame)
+		}
+
+		for i := 0; !previewMode && offset+i < buffer.LineCount(); i++ {
+			line := buffer.Line(offset + i)
+			if intLines >= int(ws.Row)-1 {
 				break
 			}
 			var lineNum, lineText string
-			if i+offset == pos2d[0] && !saving && !previewMode {
+			if i+offset == buffer.Row && !saving && !selected {
 				lineNum = SELECTEDNUM
 				lineText = SELECTEDTEXT
 			} else {
@@ -212,387 +410,623 @@ func scratchPad(buffer string) error {
 				lineText = LINETEXT
 			}
 
-			var addSpaces int
-			if previewMode {
-				// isBold := false
-				// newLine := ""
-				// for k := 0; k < length(line); k++ {
-				// 	if line[k] == '*' {
-				// 		if length(line) > k+1 && line[k+1] == '*' {
-				// 			if isBold {
-				// 				addSpaces += len("\x1b[22m")
-				// 				newLine += "\x1b[22m"
-				// 				isBold = false
-				// 			} else {
-				// 				addSpaces += len("\x1b[1m")
-				// 				newLine += "\x1b[1m"
-				// 				isBold = true
-				// 			}
-				// 			k++
-				// 		} else {
-				// 			newLine += "*"
-				// 		}
-				// 	} else {
-				// 		newLine += string(line[k])
-				// 	}
-				// }
-				// line = newLine
-				//
-				// isItalic := false
-				// newLine = ""
-				// for k := 0; k < length(line); k++ {
-				// 	if line[k] == '_' {
-				// 		if isItalic {
-				// 			addSpaces += len("\x1b[23m")
-				// 			newLine += "\x1b[23m"
-				// 			isItalic = false
-				// 		} else {
-				// 			addSpaces += len("\x1b[3m")
-				// 			newLine += "\x1b[3m"
-				// 			isItalic = true
-				// 		}
-				// 	} else {
-				// 		newLine += string(line[k])
-				// 	}
-				// }
-				// line = newLine
-
-				if HasPrefix(line, "# ") {
-					lineText = "\x1b[1m" + H1
-					line = Replace(line, "# ", "", 1)
-				} else if HasPrefix(line, "## ") {
-					lineText = "\x1b[1m" + H2
-					line = Replace(line, "## ", "", 1)
-				} else if HasPrefix(line, "### ") {
-					lineText = "\x1b[1m" + H3
-					line = Replace(line, "### ", "", 1)
-				} else if HasPrefix(line, "#### ") {
-					lineText = "\x1b[1m" + H4
-					line = Replace(line, "#### ", "", 1)
-				} else if HasPrefix(line, "##### ") {
-					lineText = "\x1b[1m" + H5
-					line = Replace(line, "##### ", "", 1)
-				} else if HasPrefix(line, "###### ") {
-					lineText = "\x1b[1m" + H6
-					line = Replace(line, "###### ", "", 1)
+			runes := []rune(line)
+			cols := int(ws.Col) - numPadding - 2
+			rows := wrap(runes, cols)
+			for j, row := range rows {
+				screenRows = append(screenRows, ScreenRow{i + offset, row, j == len(rows)-1})
+				text := paint(runes, row, spans(i+offset), lineText)
+				if j == 0 {
+					frame = append(frame, Sprintf("%s%s%d %s %s%s\x1b[0m", lineNum, pad(numPadding-len(Sprint(i+1+offset))), i+1+offset, lineText, text, pad(cols-row.Width)))
 				} else {
-					if HasPrefix(line, "> ") {
-						newLine := ""
-						for HasPrefix(line, "> ") {
-							line = Replace(line, "> ", "", 1)
-							if NERD_FONT {
-								newLine += " "
-							} else if UNICODE {
-								newLine += "▏ "
-							} else {
-								newLine += "| "
-							}
-						}
-						if HasPrefix(line, "- [x]") {
-							if NERD_FONT {
-								newLine += " "
-							} else if UNICODE {
-								newLine += "☒ "
-							} else {
-								newLine += "x "
-							}
-							line = Replace(line, "- [x]", "", 1)
-						} else if HasPrefix(line, "- [ ]") {
-							if NERD_FONT {
-								newLine += "󰄱 "
-							} else if UNICODE {
-								newLine += "☐ "
-							} else {
-								newLine += "o "
-							}
-							line = Replace(line, "- [ ]", "", 1)
-						} else if HasPrefix(line, "- ") {
-							if NERD_FONT {
-								newLine += " "
-							} else if UNICODE {
-								newLine += "• "
-							} else {
-								newLine += "- "
-							}
-							line = Replace(line, "- ", "", 1)
-						}
-						line = newLine + line
-					} else {
-						newLine := ""
-						if HasPrefix(line, "- [x]") {
-							if NERD_FONT {
-								newLine += " "
-							} else if UNICODE {
-								newLine += "☒ "
-							} else {
-								newLine += "x "
-							}
-							line = Replace(line, "- [x]", "", 1)
-						} else if HasPrefix(line, "- [ ]") {
-							if NERD_FONT {
-								newLine += "󰄱 "
-							} else if UNICODE {
-								newLine += "☐ "
-							} else {
-								newLine += "o "
-							}
-							line = Replace(line, "- [ ]", "", 1)
-						} else if HasPrefix(line, "- ") {
-							if NERD_FONT {
-								newLine += " "
-							} else if UNICODE {
-								newLine += "• "
-							} else {
-								newLine += "- "
-							}
-							line = Replace(line, "- ", "", 1)
-						}
-						line = newLine + line
-					}
+					frame = append(frame, Sprintf("%s%s%s %s %s%s\x1b[0m", lineNum, pad(numPadding-1), lineWrap, lineText, text, pad(cols-row.Width)))
 				}
 			}
-
-			if length(line) > int(ws.Col)-numPadding-2 {
-				splitLines := int(length(line) / (int(ws.Col) - numPadding - 2))
-				if length(line)%(int(ws.Col)-numPadding-2) > 0 {
-					splitLines++
-				}
-				shiftDown := 0
-				for j := 0; j < splitLines; j++ {
-					from := (int(ws.Col) - numPadding - 2) * j
-					to := from + int(ws.Col) - numPadding - 2
-					if to > length(line) {
-						to = length(line)
+			if i+offset == buffer.Row {
+				for j, row := range rows {
+					if buffer.Col < row.End || j == len(rows)-1 {
+						cursorPos[0] = intLines + j + 1
+						cursorPos[1] = runesWidth(runes[row.Start:buffer.Col]) + numPadding + 3
+						break
 					}
-					if from <= pos2d[1] && pos2d[1] <= to {
-						shiftDown = splitLines - j - 1
-					}
-					if j == 0 {
-						frame = append(frame, Sprintf("%s%s%d %s %s%s\x1b[0m", lineNum, Repeat(" ", numPadding-len(Sprint(i+1+offset))), i+1+offset, lineText, line[from:to], Repeat(" ", int(ws.Col)-length(line[from:to])-numPadding-2)))
-					} else {
-						frame = append(frame, Sprintf("%s%s%s %s %s%s", lineNum, Repeat(" ", numPadding-1), lineWrap, lineText, line[from:to], Repeat(" ", int(ws.Col)-length(line[from:to])-numPadding-2)))
-					}
-					intLines++
 				}
-				if i+offset == pos2d[0] {
-					cursorPos[0] = intLines - shiftDown
-					cursorPos[1] = (pos2d[1] % (int(ws.Col) - numPadding - 2)) + numPadding + 3
-					if pos2d[1]%(int(ws.Col)-numPadding-2) == 0 && pos2d[1]-(intLines-shiftDown-1)*(int(ws.Col)-numPadding-2) > 0 {
-						cursorPos[0]++
-						cursorPos[1] = numPadding + 3
-						frame = append(frame, Sprintf("%s%s%s %s %s", lineNum, Repeat(" ", numPadding-1), lineWrap, lineText, Repeat(" ", int(ws.Col)-numPadding-2)))
-						intLines++
-					}
-				}
-			} else {
-				frame = append(frame, Sprintf("%s%s%d %s %s%s\x1b[0m", lineNum, Repeat(" ", numPadding-len(Sprint(i+1+offset))), i+1+offset, lineText, line, Repeat(" ", int(ws.Col)-length(line)-numPadding-2+addSpaces)))
-				intLines++
-				if i+offset == pos2d[0] {
-					cursorPos[0] = intLines
-					cursorPos[1] = pos2d[1] + numPadding + 3
-					if cursorPos[1] == int(ws.Col)+1 {
-						cursorPos[0]++
-						cursorPos[1] = numPadding + 3
-						frame = append(frame, Sprintf("%s%s%s %s %s", lineNum, Repeat(" ", numPadding-1), lineWrap, lineText, Repeat(" ", int(ws.Col)-numPadding-2)))
-						intLines++
-					}
+				if cursorPos[1] > int(ws.Col) {
+					screenRows[len(screenRows)-1].Last = false
+					screenRows = append(screenRows, ScreenRow{i + offset, WrappedRow{len(runes), len(runes), 0}, true})
+					cursorPos[0]++
+					cursorPos[1] = numPadding + 3
+					frame = append(frame, Sprintf("%s%s%s %s %s", lineNum, pad(numPadding-1), lineWrap, lineText, pad(cols)))
+					intLines++
 				}
 			}
+			intLines += len(rows)
 		}
 
+		if len(frame) > int(ws.Row)-1 {
+			frame = frame[:int(ws.Row)-1]
+		}
+		// rows of a line cut off at the bottom can not be clicked, the
+		// status line is drawn there
+		if len(screenRows) > int(ws.Row)-1 {
+			screenRows = screenRows[:int(ws.Row)-1]
+		}
 		for i := 0; i < int(ws.Row)-intLines-1; i++ {
-			frame = append(frame, Sprintf("%s~%s\x1b[0m", EMPTYLINE, Repeat(" ", int(ws.Col)-1)))
+			frame = append(frame, Sprintf("%s~%s\x1b[0m", EMPTYLINE, pad(int(ws.Col)-1)))
 		}
 
-		if saving {
-			frame = append(frame, Sprintf("%s Save as: %s\x1b[48;5;252m \x1b[0m%s%s\x1b[0m", SELECTEDTEXT, savePath, SELECTEDTEXT, Repeat(" ", int(ws.Col)-11-len(savePath))))
+		if confirming {
+			frame = append(frame, Sprintf("%s %s (y/n)%s\x1b[0m", SELECTEDTEXT, question, pad(int(ws.Col)-7-width(question))))
+		} else if quitting {
+			frame = append(frame, Sprintf("%s Do you want to save changes before you exit? (y/n)%s\x1b[0m", SELECTEDTEXT, pad(int(ws.Col)-51)))
+		} else if replacing == "pattern" || replacing == "with" {
+			label, input := " Replace: ", &sub.Pattern
+			if replacing == "with" {
+				label, input = " Replace with: ", &sub.With
+			}
+			scope := ""
+			if selected {
+				scope = "[selection]"
+			} else if sub.Line {
+				scope = "[line]"
+			}
+			text, cell := input.View(int(ws.Col) - width(label) - 2 - len(scope))
+			frame = append(frame, Sprintf("%s%s%s%s%s \x1b[0m", SELECTEDTEXT, label, text, pad(int(ws.Col)-width(label)-width(text)-len(scope)-1), scope))
+			cursorPos = []int{int(ws.Row), cell + width(label) + 1}
+		} else if replacing == "confirm" {
+			frame = append(frame, Sprintf("%s Replace this match? (y)es (n)o (a)ll (q)uit%s\x1b[0m", SELECTEDTEXT, pad(int(ws.Col)-45)))
+		} else if searching {
+			counter := ""
+			if search.Query != "" {
+				counter = "no matches"
+			}
+			if current >= 0 {
+				counter = Sprintf("%d/%d", current+1, len(search.Matches))
+			}
+			if search.CaseSensitive {
+				counter += " [Aa]"
+			}
+			input, cell := search.Input.View(int(ws.Col) - 12 - len(counter))
+			frame = append(frame, Sprintf("%s Search: %s%s%s \x1b[0m", SELECTEDTEXT, input, pad(int(ws.Col)-10-width(input)-len(counter)), counter))
+			cursorPos = []int{int(ws.Row), cell + 10}
+		} else if saving {
+			input, cell := prompt.View(int(ws.Col) - 11)
+			frame = append(frame, Sprintf("%s Save as: %s%s\x1b[0m", SELECTEDTEXT, input, pad(int(ws.Col)-10-width(input))))
+			cursorPos = []int{int(ws.Row), cell + 11}
 		} else if previewMode {
-			frame = append(frame, Sprintf("%s Preview Mode %s\x1b[0m", SELECTEDTEXT, Repeat(" ", int(ws.Col)-14)))
+			frame = append(frame, Sprintf("%s Preview Mode %s\x1b[0m", SELECTEDTEXT, pad(int(ws.Col)-14)))
 		} else {
-			frame = append(frame, Sprintf("%s %d lines %s %d:%d \x1b[0m", STATUSLINE, len(lines), Repeat(" ", int(ws.Col)-11-len(Sprint(len(lines)))-len(Sprint(pos2d[0]+1))-len(Sprint(pos2d[1]+1))), pos2d[0]+1, pos2d[1]+1))
+			name := "[No Name]"
+			if path != "" {
+				name = filepath.Base(path)
+			}
+			if history.Version() != saved {
+				name += " [+]"
+			}
+			status := Sprintf("%s  %d lines", name, buffer.LineCount())
+			if overwrite {
+				status += " [OVR]"
+			}
+			if search.Query != "" {
+				if current >= 0 {
+					status += Sprintf("  [%d/%d]", current+1, len(search.Matches))
+				} else {
+					status += Sprintf("  [%d matches]", len(search.Matches))
+				}
+			}
+			if autosave && !savedAt.IsZero() {
+				status += "  saved " + savedAt.Format("15:04:05")
+			}
+			if message != "" {
+				status = message
+			}
+			position := Sprintf("%d:%d", buffer.Row+1, buffer.Col+1)
+			frame = append(frame, Sprintf("%s %s%s%s \x1b[0m", STATUSLINE, status, pad(int(ws.Col)-2-width(status)-len(position)), position))
 		}
 
-		if previewMode || saving {
-			clearScreen()
-			Print(Join(frame, "\n\r"))
-		} else {
-			Print("\x1b[?25l")
-			clearScreen()
-			Print(Join(frame, "\n\r"))
-			Printf("\x1b[%d;%dH", cursorPos[0], cursorPos[1]) // pos2d[0]-offset+1, pos2d[1]+numPadding+3
-			Print("\x1b[?25h")
+		if history.Version() != edited {
+			edited = history.Version()
+			editedAt = time.Now()
+		}
+		if autosave && path != "" && history.Version() != saved && !saving && !quitting && !confirming && !searching {
+			idle := AUTOSAVE_IDLE > 0 && time.Since(editedAt) >= time.Duration(AUTOSAVE_IDLE)*time.Second
+			left := AUTOSAVE_LINE && buffer.Row != lastRow
+			if idle || left {
+				if ok, err := save(path, false); err != nil {
+					message = "Autosave failed: " + message
+					autosave = false
+				} else if ok {
+					// the time in the status line already tells it was saved
+					message = ""
+				}
+			}
+		}
+		lastRow = buffer.Row
+
+		// the swap is written once typing has paused for a moment, a note
+		// without unsaved changes does not need one
+		if history.Version() != swapped && time.Since(swappedAt) >= time.Second {
+			if history.Version() == saved {
+				os.Remove(swap)
+			} else {
+				// another editor of the same file may have started using
+				// this swap since it was picked
+				if _, pid, ok := readSwap(swap); ok && pid != os.Getpid() {
+					swap = swapPath(path)
+				}
+				if err := writeSwap(swap, buffer.String()); err != nil {
+					message = "Could not write swap file: " + err.Error()
+				}
+			}
+			swapped = history.Version()
+			swappedAt = time.Now()
 		}
 
+		screen.Draw(frame, int(ws.Col), int(ws.Row), cursorPos, !previewMode && !quitting && !confirming)
+
 		key, err := readKey()
 		if err != nil {
-			return err
+			return "", err
 		}
-		if previewMode {
-			if key == "esc" || isCtrl(key, 'p') {
-				previewMode = false
-				Print("\x1b[?25h")
+		if key.Name != "" && key.Name != "resize" {
+			message = ""
+		}
+		chord := key.String()
+		action := KEYBINDINGS[chord]
+
+		// a movement with a "select-" action moves the cursor the same way
+		// but leaves the other end of the selection where it was, any other
+		// key drops a selection that is empty
+		editing := !quitting && !confirming && !searching && !saving && !previewMode && replacing == ""
+		if editing && HasPrefix(action, "select-") && action != "select-all" {
+			if anchor < 0 {
+				anchor = buffer.Pos()
 			}
+			action = TrimPrefix(action, "select-")
+		} else if editing && contains(MOVEMENTS, action) {
+			anchor = -1
+		} else if start, end := selection(); editing && start == end && !key.IsMouse() && key.Name != "" {
+			anchor = -1
+		}
+		start, end := selection()
+		selected = start < end
+		if key.Name == "resize" {
 			continue
-		} else {
-			if key == "backspace" {
-				if saving {
-					if len(savePath) > 0 {
-						savePath = savePath[:len(savePath)-1]
-					}
+		} else if confirming {
+			if key.String() == "y" {
+				confirming = false
+				question = ""
+				if ok, _ := save(savePath, true); ok && exitAfterSave {
+					return message, nil
+				}
+			} else if key.Name != "" && key.Name != "release" {
+				confirming = false
+				question = ""
+				if savePath != path {
+					// go back to the prompt so another name can be picked
+					saving = true
 				} else {
-					if pos1d > 0 {
-						lineLen := len(Split(buffer, "\n")[pos2d[0]])
-						if pos2d[1] > 3 && HasSuffix(buffer[pos1d-4:pos1d], "    ") && pos2d[1]%4 == 0 {
-							buffer = buffer[:pos1d-4] + buffer[pos1d:]
-							pos1d -= 4
-							pos2d[1] -= 4
-						} else {
-							buffer = buffer[:pos1d-1] + buffer[pos1d:]
-							pos1d--
-							pos2d[1]--
-						}
-						if pos2d[1] < 0 {
-							pos2d[0]--
-							pos2d[1] = len(Split(buffer, "\n")[pos2d[0]]) - lineLen
-						}
-					}
+					// autosave would only ask again, it stays off until
+					// the next save that the user makes
+					exitAfterSave = false
+					autosave = false
 				}
-			} else if key == "enter" {
-				if saving {
-					err := os.WriteFile(savePath, []byte(buffer), 0644)
-					if err != nil {
-						clearScreen()
-						return err
+			}
+		} else if quitting {
+			if key.String() == "y" {
+				quitting = false
+				exitAfterSave = true
+				if path != "" {
+					if ok, _ := save(path, false); ok {
+						return message, nil
 					}
-					clearScreen()
-					Println("Saved to", savePath, "\r")
-					Print("\x1b[?25h")
-					break
 				} else {
-					buffer = buffer[:pos1d] + "\n" + buffer[pos1d:]
-					pos1d++
-					pos2d[0]++
-					pos2d[1] = 0
-					if pos2d[0] >= int(ws.Row)-1 {
-						offset++
-					}
+					saving = true
+					prompt.Reset("")
+				}
+			} else if key.String() == "n" {
+				os.Remove(swap)
+				return "", nil
+			} else if key.Name != "" && key.Name != "release" {
+				quitting = false
+			}
+		} else if searching {
+			if chord == "enter" {
+				searching = false
+				search.Input.Remember()
+			} else if chord == "esc" {
+				searching = false
+				search.Query = ""
+				buffer.SetCursor(searchFrom.Row, searchFrom.Col)
+				offset = searchOffset
+			} else if action == "search" || action == "search-next" || action == "search-prev" {
+				search.Find(buffer)
+				if i := search.Next(buffer.Row, buffer.Col, action != "search-prev", false); i >= 0 {
+					jump(i, int(ws.Row))
+				}
+			} else if chord == "alt+c" || search.Input.Handle(key) {
+				if chord == "alt+c" {
+					search.CaseSensitive = !search.CaseSensitive
 				}
-			} else if key == "esc" {
-				if saving {
-					saving = false
-					Print("\x1b[?25h")
+				search.Query = search.Input.String()
+				search.Find(buffer)
+				if i := search.Next(searchFrom.Row, searchFrom.Col, true, true); i >= 0 {
+					jump(i, int(ws.Row))
 				} else {
-					if len(buffer) == 0 {
-						clearScreen()
-						return nil
-					} else {
-						for {
-							Printf("\x1b[%d;1H%s Do you want to save changes before you exit? (y/n)%s\x1b[0m", int(ws.Row), SELECTEDTEXT, Repeat(" ", int(ws.Col)-51))
-							Printf("\x1b[%d;%dH", cursorPos[0], cursorPos[1])
-							key, err := readKey()
-							if err != nil {
-								return err
-							}
-							if key == "y" {
-								saving = true
-								break
-							} else if key == "n" {
-								clearScreen()
-								return nil
-							} else if key != "" {
-								break
-							}
-						}
-					}
+					buffer.SetCursor(searchFrom.Row, searchFrom.Col)
+					offset = searchOffset
 				}
-			} else if key == "tab" {
-				if !saving {
-					spaces := 4 - pos2d[1]%4
-					buffer = buffer[:pos1d] + Repeat(" ", spaces) + buffer[pos1d:]
-					pos1d += spaces
-					pos2d[1] += spaces
-				}
-			} else if isChar(key) {
-				if saving {
-					if key == " " {
-						savePath += "\\ "
-					} else {
-						savePath += key
-					}
+			}
+		} else if replacing == "pattern" || replacing == "with" {
+			if chord == "enter" && replacing == "pattern" {
+				if _, err := regexp.Compile(sub.Pattern.String()); err != nil {
+					replacing = ""
+					message = "Invalid pattern: " + err.Error()
+				} else if sub.Pattern.String() != "" {
+					sub.Pattern.Remember()
+					sub.With.Reset("")
+					replacing = "with"
+				}
+			} else if chord == "enter" {
+				sub.With.Remember()
+				start, end := 0, buffer.Len()
+				if selected {
+					start, end = selection()
+					anchor = -1
+				} else if sub.Line {
+					start = buffer.Offset(buffer.Row, 0)
+					end = start + buffer.LineLen(buffer.Row)
+				}
+				sub.Begin(buffer, start, end)
+				if sub.Next(buffer) {
+					replacing = "confirm"
 				} else {
-					buffer = buffer[:pos1d] + key + buffer[pos1d:]
-					pos1d++
-					pos2d[1]++
-				}
-			} else if key == "up" {
-				if !saving {
-					if pos2d[0] > 0 {
-						pos2d[0]--
-						if pos2d[1] > len(Split(buffer, "\n")[pos2d[0]]) {
-							if len(Split(buffer, "\n")[pos2d[0]]) == 0 {
-								pos2d[1] = 0
-							} else {
-								pos2d[1] = len(Split(buffer, "\n")[pos2d[0]]) - 1
-							}
-						}
-						pos1d = 0
-						for i := 0; i < pos2d[0]; i++ {
-							pos1d += len(Split(buffer, "\n")[i]) + 1
-						}
-						pos1d += pos2d[1]
-					}
-					if pos2d[0] < offset {
-						offset--
-					}
+					replacing = ""
+					message = "No matches for " + sub.Pattern.String()
+				}
+			} else if chord == "esc" {
+				replacing = ""
+			} else if chord == "alt+l" {
+				sub.Line = !sub.Line
+			} else if replacing == "pattern" {
+				sub.Pattern.Handle(key)
+			} else {
+				sub.With.Handle(key)
+			}
+		} else if replacing == "confirm" {
+			if chord == "y" || chord == "n" || chord == "a" {
+				if chord == "n" {
+					sub.Skip()
+				} else {
+					sub.Apply(buffer)
+				}
+				for chord == "a" && sub.Next(buffer) {
+					sub.Apply(buffer)
+				}
+				if chord == "a" || !sub.Next(buffer) {
+					replacing = ""
+				}
+			} else if chord == "q" || chord == "esc" {
+				replacing = ""
+			}
+			if replacing == "" {
+				message = Sprintf("Replaced %d matches", sub.Finish(buffer, &history))
+			}
+		} else if action == "replace" && !previewMode {
+			replacing = "pattern"
+			sub.Pattern.Reset("")
+		} else if (action == "search" || previewMode && chord == "/") || (action == "search-next" || action == "search-prev") && search.Query == "" {
+			searching = true
+			searchFrom = buffer.Cursor()
+			searchOffset = offset
+			search.Input.Reset("")
+			search.Query = ""
+		} else if action == "search-next" || action == "search-prev" || previewMode && (chord == "n" || chord == "N") {
+			forward := action == "search-next" || chord == "n"
+			if i := search.Next(buffer.Row, buffer.Col, forward, false); i >= 0 {
+				jump(i, int(ws.Row))
+			} else {
+				message = "No matches for " + search.Query
+			}
+		} else if previewMode {
+			if action == "quit" || action == "preview" {
+				previewMode = false
+			} else if chord == "wheelup" {
+				offset -= 3
+				if offset < 0 {
+					offset = 0
+				}
+			} else if chord == "wheeldown" {
+				offset += 3
+				if offset > buffer.LineCount()-1 {
+					offset = buffer.LineCount() - 1
 				}
-			} else if key == "down" {
-				if !saving {
-					if pos2d[0] < len(Split(buffer, "\n"))-1 {
-						pos2d[0]++
-						if pos2d[1] > len(Split(buffer, "\n")[pos2d[0]]) {
-							if len(Split(buffer, "\n")[pos2d[0]]) == 0 {
-								pos2d[1] = 0
-							} else {
-								pos2d[1] = len(Split(buffer, "\n")[pos2d[0]]) - 1
-							}
-						}
-						pos1d = 0
-						for i := 0; i < pos2d[0]; i++ {
-							pos1d += len(Split(buffer, "\n")[i]) + 1
-						}
-						pos1d += pos2d[1]
+			}
+			continue
+		} else if saving {
+			if chord == "enter" && prompt.String() != "" {
+				prompt.Remember()
+				target := expandPath(prompt.String())
+				saving = false
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					message = saveError(filepath.Dir(target), err)
+				} else if ok, _ := save(target, false); ok && exitAfterSave {
+					return message, nil
+				}
+			} else if chord == "esc" {
+				saving = false
+				exitAfterSave = false
+			} else {
+				prompt.Handle(key)
+			}
+		} else if key.IsMouse() {
+			if key.Name == "wheelup" || key.Name == "wheeldown" {
+				if key.Name == "wheelup" {
+					offset -= 3
+					if offset < 0 {
+						offset = 0
 					}
-					if pos2d[0] >= offset+int(ws.Row)-1 {
-						offset++
+				} else {
+					offset += 3
+					if offset > buffer.LineCount()-1 {
+						offset = buffer.LineCount() - 1
 					}
 				}
-			} else if key == "right" {
-				if !saving {
-					if pos2d[1] < len(Split(buffer, "\n")[pos2d[0]]) {
-						pos2d[1]++
-						pos1d++
-					}
+				// the empty selection a click leaves behind must not grow
+				// when scrolling drags the cursor along
+				if anchor == buffer.Pos() {
+					anchor = -1
+				}
+				if buffer.Row < offset {
+					buffer.SetCursor(offset, buffer.Col)
+				} else if last := lastVisible(ws); buffer.Row > last {
+					buffer.SetCursor(last, buffer.Col)
 				}
-			} else if key == "left" {
-				if !saving {
-					if pos2d[1] > 0 {
-						pos2d[1]--
-						pos1d--
+				buffer.SetCursor(buffer.Row, snapToCluster([]rune(buffer.Line(buffer.Row)), buffer.Col))
+			} else if key.Name == "click" || key.Name == "drag" {
+				history.Break()
+				if key.Name == "click" {
+					anchor = -1
+				} else if anchor < 0 {
+					anchor = buffer.Pos()
+				}
+				if key.Y >= 1 && key.Y-1 < len(screenRows) {
+					row := screenRows[key.Y-1]
+					runes := []rune(buffer.Line(row.Line))
+					col := cellToIndex(runes, row.WrappedRow, key.X-numPadding-3)
+					if col == row.End && !row.Last && col > row.Start {
+						col = clusterStart(runes, col)
 					}
+					buffer.SetCursor(row.Line, col)
+				} else if key.Y < int(ws.Row) {
+					buffer.SetCursor(buffer.LineCount()-1, buffer.LineLen(buffer.LineCount()-1))
+				}
+				if key.Name == "click" {
+					anchor = buffer.Pos()
+				}
+			}
+		} else if key.Name == "paste" {
+			if selected {
+				replaceSelection(key.Text)
+			} else if key.Text != "" {
+				before := buffer.Cursor()
+				buffer.Insert(before.Pos, key.Text)
+				history.Record("", Edit{Pos: before.Pos, Inserted: key.Text}, before, buffer.Cursor())
+			}
+		} else if selected && (action == "backspace" || action == "delete") {
+			replaceSelection("")
+		} else if selected && action == "newline" {
+			replaceSelection("\n")
+		} else if selected && action == "indent" {
+			replaceSelection(Repeat(" ", tabWidth))
+		} else if action == "backspace" {
+			before := buffer.Cursor()
+			if before.Pos > 0 {
+				runes := []rune(buffer.Line(buffer.Row))
+				n := 1
+				if buffer.Col > 3 && string(runes[buffer.Col-4:buffer.Col]) == "    " && buffer.Col%4 == 0 {
+					n = 4
+				} else if buffer.Col > 0 {
+					n = buffer.Col - clusterStart(runes, buffer.Col)
+				}
+				removed := buffer.Delete(before.Pos-n, n)
+				history.Record("delete", Edit{Pos: before.Pos - n, Removed: removed}, before, buffer.Cursor())
+			}
+		} else if action == "delete" {
+			before := buffer.Cursor()
+			if before.Pos < buffer.Len() {
+				n := 1
+				if buffer.Col < buffer.LineLen(buffer.Row) {
+					n = clusterEnd([]rune(buffer.Line(buffer.Row)), buffer.Col) - buffer.Col
+				}
+				removed := buffer.Delete(before.Pos, n)
+				history.Record("delete", Edit{Pos: before.Pos, Removed: removed}, before, buffer.Cursor())
+			}
+		} else if action == "newline" {
+			before := buffer.Cursor()
+			buffer.Insert(before.Pos, "\n")
+			history.Record("", Edit{Pos: before.Pos, Inserted: "\n"}, before, buffer.Cursor())
+		} else if action == "quit" {
+			if history.Version() == saved {
+				os.Remove(swap)
+				return "", nil
+			} else {
+				quitting = true
+			}
+		} else if action == "indent" {
+			spaces := Repeat(" ", 4-buffer.Col%4)
+			before := buffer.Cursor()
+			buffer.Insert(before.Pos, spaces)
+			history.Record("type", Edit{Pos: before.Pos, Inserted: spaces}, before, buffer.Cursor())
+		} else if action == "format-table" {
+			table := blockAt(parseMarkdown(buffer.String()), buffer.Row, TableBlock)
+			if table == nil {
+				message = "The cursor is not in a table"
+			} else {
+				var lines []string
+				for row := table.Line; row < table.End; row++ {
+					lines = append(lines, buffer.Line(row))
+				}
+				original, formatted := Join(lines, "\n"), Join(formatTable(lines, table.Align), "\n")
+				if formatted != original {
+					before := buffer.Cursor()
+					cell := tableCell(buffer.Line(buffer.Row), buffer.Col)
+					start := buffer.Offset(table.Line, 0)
+					removed := buffer.Delete(start, length(original))
+					buffer.Insert(start, formatted)
+					buffer.SetCursor(before.Row, cellStart(buffer.Line(before.Row), cell))
+					history.Record("", Edit{Pos: start, Removed: removed, Inserted: formatted}, before, buffer.Cursor())
+					anchor = -1
+				}
+			}
+		} else if action == "up" {
+			history.Break()
+			if buffer.Row > 0 {
+				col := buffer.Col
+				if col > buffer.LineLen(buffer.Row-1) {
+					col = buffer.LineLen(buffer.Row-1) - 1
+				}
+				buffer.SetCursor(buffer.Row-1, snapToCluster([]rune(buffer.Line(buffer.Row-1)), col))
+			}
+		} else if action == "down" {
+			history.Break()
+			if buffer.Row < buffer.LineCount()-1 {
+				col := buffer.Col
+				if col > buffer.LineLen(buffer.Row+1) {
+					col = buffer.LineLen(buffer.Row+1) - 1
+				}
+				buffer.SetCursor(buffer.Row+1, snapToCluster([]rune(buffer.Line(buffer.Row+1)), col))
+			}
+		} else if action == "right" {
+			history.Break()
+			if buffer.Col < buffer.LineLen(buffer.Row) {
+				buffer.Col = clusterEnd([]rune(buffer.Line(buffer.Row)), buffer.Col)
+			}
+		} else if action == "left" {
+			history.Break()
+			if buffer.Col > 0 {
+				buffer.Col = clusterStart([]rune(buffer.Line(buffer.Row)), buffer.Col)
+			}
+		} else if action == "word-right" {
+			history.Break()
+			buffer.WordRight()
+		} else if action == "word-left" {
+			history.Break()
+			buffer.WordLeft()
+		} else if action == "line-start" {
+			history.Break()
+			buffer.Col = 0
+		} else if action == "line-end" {
+			history.Break()
+			buffer.Col = buffer.LineLen(buffer.Row)
+		} else if action == "note-start" {
+			history.Break()
+			buffer.SetCursor(0, 0)
+		} else if action == "note-end" {
+			history.Break()
+			buffer.SetCursor(buffer.LineCount()-1, buffer.LineLen(buffer.LineCount()-1))
+		} else if action == "page-up" || action == "page-down" {
+			history.Break()
+			page := int(ws.Row) - 1
+			if action == "page-up" {
+				page = -page
+			}
+			row := buffer.Row + page
+			if row < 0 {
+				row = 0
+			} else if row > buffer.LineCount()-1 {
+				row = buffer.LineCount() - 1
+			}
+			offset += page
+			if offset > buffer.LineCount()-1 {
+				offset = buffer.LineCount() - 1
+			}
+			if offset < 0 {
+				offset = 0
+			}
+			buffer.SetCursor(row, snapToCluster([]rune(buffer.Line(row)), buffer.Col))
+		} else if action == "scroll-up" || action == "scroll-down" {
+			if action == "scroll-up" && offset > 0 {
+				offset--
+			} else if action == "scroll-down" && offset < buffer.LineCount()-1 {
+				offset++
+			}
+			if buffer.Row < offset {
+				buffer.SetCursor(offset, buffer.Col)
+			} else if last := lastVisible(ws); buffer.Row > last {
+				buffer.SetCursor(last, buffer.Col)
+			}
+		} else if action == "overwrite" {
+			overwrite = !overwrite
+		} else if action == "save" && path != "" {
+			save(path, false)
+		} else if action == "save" || action == "save-as" {
+			saving = true
+			prompt.Reset(path)
+		} else if action == "preview" {
+			previewMode = !previewMode
+		} else if action == "undo" {
+			anchor = -1
+			history.Undo(buffer)
+		} else if action == "redo" {
+			anchor = -1
+			history.Redo(buffer)
+		} else if action == "select-all" {
+			anchor = 0
+			buffer.MoveTo(buffer.Len())
+		} else if action == "copy" || action == "cut" {
+			// without a selection the whole line is taken, it is pasted
+			// back as a line of its own above the cursor
+			clipboardLines = !selected
+			if clipboardLines {
+				start = buffer.Offset(buffer.Row, 0)
+				end = start + buffer.LineLen(buffer.Row)
+				if end < buffer.Len() {
+					end++
+				} else if start > 0 {
+					start--
 				}
-			} else if isCtrl(key, 's') {
-				saving = true
-				Print("\x1b[?25l")
-			} else if isCtrl(key, 'p') {
-				previewMode = !previewMode
-				Print("\x1b[?25l")
+				clipboard = buffer.Line(buffer.Row) + "\n"
 			} else {
-				continue
+				clipboard = buffer.Text(start, end-start)
+			}
+			if err := copySystem(clipboard); err != nil {
+				message = "Could not copy to the system clipboard: " + err.Error()
+			}
+			if action == "cut" {
+				before := buffer.Cursor()
+				removed := buffer.Delete(start, end-start)
+				buffer.MoveTo(start)
+				history.Record("", Edit{Pos: start, Removed: removed}, before, buffer.Cursor())
+				anchor = -1
 			}
+		} else if action == "paste" {
+			// text copied in another program replaces what was copied here
+			if text, ok := pasteSystem(); ok && text != clipboard {
+				clipboard = text
+				clipboardLines = false
+			}
+			if clipboardLines && !selected {
+				before := buffer.Cursor()
+				pos := buffer.Offset(buffer.Row, 0)
+				buffer.Insert(pos, clipboard)
+				history.Record("", Edit{Pos: pos, Inserted: clipboard}, before, buffer.Cursor())
+			} else if clipboard != "" || selected {
+				replaceSelection(clipboard)
+			}
+		} else if selected && key.IsChar() {
+			replaceSelection(key.Name)
+		} else if key.IsChar() {
+			before := buffer.Cursor()
+			removed := ""
+			if overwrite && buffer.Col < buffer.LineLen(buffer.Row) {
+				removed = buffer.Delete(before.Pos, clusterEnd([]rune(buffer.Line(buffer.Row)), buffer.Col)-buffer.Col)
+			}
+			buffer.Insert(before.Pos, key.Name)
+			history.Record("type", Edit{Pos: before.Pos, Removed: removed, Inserted: key.Name}, before, buffer.Cursor())
+		} else {
+			continue
 		}
 	}
-
-	return nil
 }
 
 func hexToAnsi(hex string, fg bool) string {
@@ -662,6 +1096,11 @@ func parseConfig(contents string) {
 					value += string(contents[i])
 					break
 				}
+				// a value without quotes ends at whitespace so a comment can
+				// follow it on the same line
+				if contents[i] == ' ' || contents[i] == '\t' || contents[i] == '#' {
+					break
+				}
 				value += string(contents[i])
 			}
 			for ; i < len(contents) && contents[i] != '\n'; i++ {
@@ -685,6 +1124,44 @@ func parseConfig(contents string) {
 					Printf("Invalid value for unicode in config file: %s\n", value)
 					os.Exit(1)
 				}
+			} else if key == "backup" {
+				if value != "off" && value != "simple" && value != "timestamp" {
+					Printf("Invalid value for backup in config file: %s\n", value)
+					os.Exit(1)
+				}
+				BACKUP = value
+			} else if key == "autosave_idle" {
+				seconds, err := Atoi(value)
+				if err != nil || seconds < 0 {
+					Printf("Invalid value for autosave_idle in config file: %s\n", value)
+					os.Exit(1)
+				}
+				AUTOSAVE_IDLE = seconds
+			} else if key == "autosave_line" {
+				if value == "true" {
+					AUTOSAVE_LINE = true
+				} else if value == "false" {
+					AUTOSAVE_LINE = false
+				} else {
+					Printf("Invalid value for autosave_line in config file: %s\n", value)
+					os.Exit(1)
+				}
+			} else if key == "clipboard" {
+				if value != "auto" && value != "internal" && value != "osc52" && value != "command" {
+					Printf("Invalid value for clipboard in config file: %s\n", value)
+					os.Exit(1)
+				}
+				CLIPBOARD = value
+			} else if key == "clipboard_copy" || key == "clipboard_paste" {
+				if !HasPrefix(value, "\"") || !HasSuffix(value, "\"") || len(value) < 3 {
+					Printf("Invalid %s in config file: %s\n", key, value)
+					os.Exit(1)
+				}
+				if key == "clipboard_copy" {
+					CLIPBOARD_COPY = value[1 : len(value)-1]
+				} else {
+					CLIPBOARD_PASTE = value[1 : len(value)-1]
+				}
 			} else if key == "theme" {
 				if !HasPrefix(value, "\"") || !HasSuffix(value, "\"") {
 					Printf("Invalid theme in config file: %s\n", value)
@@ -733,6 +1210,34 @@ func parseConfig(contents string) {
 				SELECTED_TEXT_FG = hexToAnsi(value, true)
 			} else if key == "bg_selected_text" {
 				SELECTED_TEXT_BG = hexToAnsi(value, false)
+			} else if key == "fg_search" {
+				SEARCH_FG = hexToAnsi(value, true)
+			} else if key == "bg_search" {
+				SEARCH_BG = hexToAnsi(value, false)
+			} else if key == "fg_search_current" {
+				SEARCH_CURRENT_FG = hexToAnsi(value, true)
+			} else if key == "bg_search_current" {
+				SEARCH_CURRENT_BG = hexToAnsi(value, false)
+			} else if key == "fg_code" {
+				CODE_FG = hexToAnsi(value, true)
+			} else if key == "bg_code" {
+				CODE_BG = hexToAnsi(value, false)
+			} else if key == "fg_code_block" {
+				CODE_BLOCK_FG = hexToAnsi(value, true)
+			} else if key == "bg_code_block" {
+				CODE_BLOCK_BG = hexToAnsi(value, false)
+			} else if key == "fg_code_label" {
+				CODE_LABEL_FG = hexToAnsi(value, true)
+			} else if key == "fg_keyword" {
+				KEYWORD_FG = hexToAnsi(value, true)
+			} else if key == "fg_type" {
+				TYPE_FG = hexToAnsi(value, true)
+			} else if key == "fg_string" {
+				STRING_FG = hexToAnsi(value, true)
+			} else if key == "fg_number" {
+				NUMBER_FG = hexToAnsi(value, true)
+			} else if key == "fg_comment" {
+				COMMENT_FG = hexToAnsi(value, true)
 			} else if key == "h1" {
 				H1 = hexToAnsi(value, true)
 			} else if key == "h2" {
@@ -758,6 +1263,11 @@ func parseConfig(contents string) {
 	STATUSLINE = STATUS_LINE_FG + STATUS_LINE_BG
 	SELECTEDNUM = SELECTED_NUM_FG + SELECTED_NUM_BG
 	SELECTEDTEXT = SELECTED_TEXT_FG + SELECTED_TEXT_BG
+	SEARCH = SEARCH_FG + SEARCH_BG
+	SEARCHCURRENT = SEARCH_CURRENT_FG + SEARCH_CURRENT_BG
+	CODE = CODE_FG + CODE_BG
+	CODEBLOCK = CODE_BLOCK_FG + CODE_BLOCK_BG
+	CODELABEL = CODE_LABEL_FG + CODE_BLOCK_BG
 }
 
 func init() {
@@ -776,44 +1286,72 @@ func init() {
 		}
 	}
 	parseConfig(string(contents))
-}
 
-func main() {
-	oldState, err := setRawTerminal()
-	if err != nil {
-		Println(err, "\r")
-		os.Exit(1)
+	contents = nil
+	if _, err := os.Stat(os.ExpandEnv("$HOME/.config/scratchpad/keys.conf")); err == nil {
+		contents, err = os.ReadFile(os.ExpandEnv("$HOME/.config/scratchpad/keys.conf"))
+		if err != nil {
+			Println(err)
+			os.Exit(1)
+		}
+	} else if _, err := os.Stat(os.ExpandEnv("$HOME/.scratchpad.keys.conf")); err == nil {
+		contents, err = os.ReadFile(os.ExpandEnv("$HOME/.scratchpad.keys.conf"))
+		if err != nil {
+			Println(err)
+			os.Exit(1)
+		}
 	}
-	defer restoreTerminal(oldState)
+	parseKeybindings(string(contents))
+}
 
-	var buffer string
+func main() {
+	var contents []byte
+	var path string
 	if len(os.Args) >= 2 {
 		if os.Args[1] == "--create-config" {
 
 		} else if os.Args[1] == "-v" || os.Args[1] == "--version" {
-			Println("ScratchPad version", VERSION, "\r")
-			restoreTerminal(oldState)
+			Println("ScratchPad version", VERSION)
 			os.Exit(0)
 		} else if os.Args[1] == "-h" || os.Args[1] == "--help" {
-			Println("Usage: scratchpad [file]\r")
-			Println("  -h, --help     display this help and exit\r")
-			Println("  -v, --version  output version information and exit\r")
-			restoreTerminal(oldState)
+			Println("Usage: scratchpad [file]")
+			Println("  -h, --help     display this help and exit")
+			Println("  -v, --version  output version information and exit")
 			os.Exit(0)
 		}
-		contents, err := os.ReadFile(os.Args[1])
-		if err != nil {
-			Println(err, "\r")
-			restoreTerminal(oldState)
+		path = os.Args[1]
+		var err error
+		contents, err = os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			Println(err)
 			os.Exit(1)
 		}
-		buffer = string(contents)
 	}
 
-	err = scratchPad(buffer)
+	text, recovered := recoverSwap(path, string(contents))
+
+	oldState, err := setRawTerminal()
 	if err != nil {
-		Println(err, "\r")
-		restoreTerminal(oldState)
+		restoreTerminal(nil)
+		Println(err)
 		os.Exit(1)
 	}
+	// a panic must not leave the terminal raw on the alternate screen with
+	// mouse reporting on, it is restored before the panic is printed
+	defer func() {
+		if r := recover(); r != nil {
+			restoreTerminal(oldState)
+			panic(r)
+		}
+	}()
+
+	message, err := scratchPad(NewBuffer(text), path, recovered)
+	restoreTerminal(oldState)
+	if err != nil {
+		Println(err)
+		os.Exit(1)
+	}
+	if message != "" {
+		Println(message)
+	}
 }
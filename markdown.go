@@ -0,0 +1,460 @@
+package main
+
+import (
+	"regexp"
+	. "strconv"
+	. "strings"
+)
+
+type BlockKind int
+
+const (
+	ParagraphBlock BlockKind = iota
+	HeadingBlock
+	QuoteBlock
+	ListBlock
+	ItemBlock
+	CodeBlock
+	TableBlock
+	RuleBlock
+)
+
+// Block is a node of a parsed markdown note, Line and End are the lines of
+// the note it covers (End is not included) so whatever shows the blocks can
+// point back to the note
+type Block struct {
+	Kind BlockKind
+	Line int
+	End  int
+
+	// Text holds the lines of a paragraph, heading or code block and Lines
+	// the line of the note each of them comes from
+	Text  []string
+	Lines []int
+
+	// Children are the blocks inside a quote or list item and the items of
+	// a list
+	Children []*Block
+
+	// Level is the level of a heading
+	Level int
+
+	// Marker is the bullet of a list ("-", "*" or "+") or the character after
+	// the number of an ordered one ("." or ")"), Number is the number of an
+	// ordered list item
+	Marker  string
+	Ordered bool
+	Number  int
+
+	// Task is set for "- [ ]" items and Checked when they are ticked
+	Task    bool
+	Checked bool
+
+	// Info is what follows the opening fence of a code block, the first word
+	// of it is the language
+	Info  string
+	Fence bool
+
+	// Cells are the rows of a table with the header first, Align is "left",
+	// "center", "right" or "" for each column
+	Cells [][]string
+	Align []string
+}
+
+// Language returns the language of a fenced code block
+func (b *Block) Language() string {
+	if fields := Fields(b.Info); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// parseMarkdown splits a note into blocks
+func parseMarkdown(text string) []*Block {
+	return parseBlocks(Split(text, "\n"), 0)
+}
+
+var (
+	atxHeading   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	openingFence = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})(.*)$")
+	rule         = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextH1     = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	setextH2     = regexp.MustCompile(`^ {0,3}-+[ \t]*$`)
+	quoteMarker  = regexp.MustCompile(`^ {0,3}> ?`)
+	listItem     = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])([ \t]+|$)`)
+	taskMarker   = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+|$)`)
+	tableAlign   = regexp.MustCompile(`^:?-+:?$`)
+)
+
+func isBlank(line string) bool {
+	return TrimSpace(line) == ""
+}
+
+// indentOf returns how many columns of whitespace a line starts with, a tab
+// goes to the next multiple of four like it does in markdown
+func indentOf(line string) int {
+	columns := 0
+	for _, c := range line {
+		if c == ' ' {
+			columns++
+		} else if c == '\t' {
+			columns += 4 - columns%4
+		} else {
+			break
+		}
+	}
+	return columns
+}
+
+// dropIndent removes up to n columns of leading whitespace
+func dropIndent(line string, n int) string {
+	columns := 0
+	for i, c := range line {
+		if columns >= n || (c != ' ' && c != '\t') {
+			return line[i:]
+		}
+		if c == ' ' {
+			columns++
+		} else {
+			tab := 4 - columns%4
+			if columns+tab > n {
+				// a tab wider than what is left keeps the rest of it as spaces
+				return Repeat(" ", columns+tab-n) + line[i+1:]
+			}
+			columns += tab
+		}
+	}
+	return ""
+}
+
+// expandTabsFrom turns the tabs in the whitespace s starts with into spaces,
+// column is where s starts in its line so the tabs stop where they should
+func expandTabsFrom(s string, column int) string {
+	spaces := 0
+	for i, c := range s {
+		if c == ' ' {
+			spaces++
+		} else if c == '\t' {
+			spaces += 4 - (column+spaces)%4
+		} else {
+			return Repeat(" ", spaces) + s[i:]
+		}
+	}
+	return Repeat(" ", spaces)
+}
+
+// listMarker reports whether line starts a list item, content is the column
+// the text of the item starts at and text is the first line of it
+func listMarker(line string) (marker string, number int, content int, text string, ok bool) {
+	m := listItem.FindStringSubmatch(line)
+	if m == nil || rule.MatchString(line) {
+		return "", 0, 0, "", false
+	}
+	marker = m[2]
+	if len(marker) > 1 {
+		number, _ = Atoi(marker[:len(marker)-1])
+		marker = marker[len(marker)-1:]
+	}
+
+	column := len(m[1]) + len(m[2])
+	rest := expandTabsFrom(line[column:], column)
+	spaces := len(rest) - len(TrimLeft(rest, " "))
+	if isBlank(rest) {
+		return marker, number, column + 1, "", true
+	}
+	if spaces > 4 {
+		// more than four spaces start an indented code block in the item
+		spaces = 1
+	}
+	return marker, number, column + spaces, rest[spaces:], true
+}
+
+// splitRow splits a table row into its cells, escaped pipes stay in the
+// cell and are turned into plain pipes by the inline parser
+func splitRow(line string) []string {
+	line = TrimSpace(line)
+	line = TrimPrefix(line, "|")
+	if HasSuffix(line, "|") && !HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '|' {
+			i++
+		} else if line[i] == '|' {
+			cells = append(cells, TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(cells, TrimSpace(line[start:]))
+}
+
+// tableStart reports whether a table starts at line i, which needs a header
+// row followed by a row of dashes with the same number of cells
+func tableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !Contains(lines[i], "|") || !Contains(lines[i+1], "-") {
+		return false
+	}
+	header, delimiter := splitRow(lines[i]), splitRow(lines[i+1])
+	if len(header) != len(delimiter) {
+		return false
+	}
+	for _, cell := range delimiter {
+		if !tableAlign.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// interrupts reports whether line starts a block that ends a paragraph
+func interrupts(line string) bool {
+	if atxHeading.MatchString(line) || isFence(line) || rule.MatchString(line) || quoteMarker.MatchString(line) {
+		return true
+	}
+	// only lists that start at one and are not empty can interrupt, so a
+	// wrapped line that happens to start with a number stays in its paragraph
+	if _, number, _, text, ok := listMarker(line); ok && number <= 1 {
+		return text != ""
+	}
+	return false
+}
+
+func isListItem(line string) bool {
+	_, _, _, _, ok := listMarker(line)
+	return ok
+}
+
+// isFence reports whether line opens a fenced code block, the info string
+// of a backtick fence cannot have backticks in it
+func isFence(line string) bool {
+	m := openingFence.FindStringSubmatch(line)
+	return m != nil && !(m[2][0] == '`' && Contains(m[3], "`"))
+}
+
+// blockAt returns the innermost block of kind that covers line of the note,
+// or nil when there is none
+func blockAt(blocks []*Block, line int, kind BlockKind) *Block {
+	for _, block := range blocks {
+		if line < block.Line || line >= block.End {
+			continue
+		}
+		if inner := blockAt(block.Children, line, kind); inner != nil {
+			return inner
+		}
+		if block.Kind == kind {
+			return block
+		}
+	}
+	return nil
+}
+
+// parseBlocks parses lines into blocks, first is the line of the note that
+// lines[0] is so nested blocks still know where they come from
+func parseBlocks(lines []string, first int) []*Block {
+	var blocks []*Block
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case isFence(line):
+			block, end := parseFence(lines, i, first)
+			blocks = append(blocks, block)
+			i = end
+
+		case atxHeading.MatchString(line):
+			m := atxHeading.FindStringSubmatch(line)
+			blocks = append(blocks, &Block{Kind: HeadingBlock, Line: first + i, End: first + i + 1, Level: len(m[1]), Text: []string{m[2]}, Lines: []int{first + i}})
+			i++
+
+		case rule.MatchString(line):
+			blocks = append(blocks, &Block{Kind: RuleBlock, Line: first + i, End: first + i + 1})
+			i++
+
+		case quoteMarker.MatchString(line):
+			var inner []string
+			j := i
+			for j < len(lines) && quoteMarker.MatchString(lines[j]) {
+				inner = append(inner, quoteMarker.ReplaceAllString(lines[j], ""))
+				j++
+			}
+			blocks = append(blocks, &Block{Kind: QuoteBlock, Line: first + i, End: first + j, Children: parseBlocks(inner, first+i)})
+			i = j
+
+		case indentOf(line) >= 4:
+			block := &Block{Kind: CodeBlock, Line: first + i}
+			j := i
+			for j < len(lines) && (indentOf(lines[j]) >= 4 || isBlank(lines[j])) {
+				block.Text = append(block.Text, dropIndent(lines[j], 4))
+				block.Lines = append(block.Lines, first+j)
+				j++
+			}
+			for isBlank(block.Text[len(block.Text)-1]) {
+				block.Text = block.Text[:len(block.Text)-1]
+				block.Lines = block.Lines[:len(block.Lines)-1]
+				j--
+			}
+			block.End = first + j
+			blocks = append(blocks, block)
+			i = j
+
+		case isListItem(line):
+			block, end := parseList(lines, i, first)
+			blocks = append(blocks, block)
+			i = end
+
+		case tableStart(lines, i):
+			block := &Block{Kind: TableBlock, Line: first + i, Cells: [][]string{splitRow(line)}}
+			for _, cell := range splitRow(lines[i+1]) {
+				align := ""
+				if HasPrefix(cell, ":") && HasSuffix(cell, ":") {
+					align = "center"
+				} else if HasPrefix(cell, ":") {
+					align = "left"
+				} else if HasSuffix(cell, ":") {
+					align = "right"
+				}
+				block.Align = append(block.Align, align)
+			}
+			block.Lines = []int{first + i}
+			j := i + 2
+			for j < len(lines) && !isBlank(lines[j]) && !interrupts(lines[j]) {
+				row := splitRow(lines[j])
+				// rows are cut or filled up to the columns of the header
+				for len(row) < len(block.Align) {
+					row = append(row, "")
+				}
+				block.Cells = append(block.Cells, row[:len(block.Align)])
+				block.Lines = append(block.Lines, first+j)
+				j++
+			}
+			block.End = first + j
+			blocks = append(blocks, block)
+			i = j
+
+		default:
+			block := &Block{Kind: ParagraphBlock, Line: first + i}
+			j := i
+			for j < len(lines) && !isBlank(lines[j]) {
+				if j > i && (setextH1.MatchString(lines[j]) || setextH2.MatchString(lines[j])) {
+					// a line of = or - under a paragraph makes it a heading
+					block.Kind = HeadingBlock
+					block.Level = 1
+					if setextH2.MatchString(lines[j]) {
+						block.Level = 2
+					}
+					j++
+					break
+				}
+				if j > i && (interrupts(lines[j]) || tableStart(lines, j)) {
+					break
+				}
+				block.Text = append(block.Text, TrimSpace(lines[j]))
+				block.Lines = append(block.Lines, first+j)
+				j++
+			}
+			block.End = first + j
+			blocks = append(blocks, block)
+			i = j
+		}
+	}
+	return blocks
+}
+
+// parseFence parses a fenced code block starting at line i and returns it
+// with the index of the line after it, a fence that is never closed runs to
+// the end of the note
+func parseFence(lines []string, i int, first int) (*Block, int) {
+	m := openingFence.FindStringSubmatch(lines[i])
+	indent, fence := len(m[1]), m[2]
+	block := &Block{Kind: CodeBlock, Line: first + i, Fence: true, Info: TrimSpace(m[3])}
+
+	j := i + 1
+	for ; j < len(lines); j++ {
+		line := lines[j]
+		if indentOf(line) < 4 && HasPrefix(TrimSpace(line), fence) && Trim(TrimSpace(line), fence[:1]) == "" {
+			j++
+			break
+		}
+		block.Text = append(block.Text, dropIndent(line, indent))
+		block.Lines = append(block.Lines, first+j)
+	}
+	block.End = first + j
+	return block, j
+}
+
+// parseList parses the items of a list starting at line i, items with a
+// different bullet or delimiter start a new list like in markdown
+func parseList(lines []string, i int, first int) (*Block, int) {
+	marker, number, _, _, _ := listMarker(lines[i])
+	list := &Block{Kind: ListBlock, Line: first + i, Marker: marker, Ordered: number > 0 || marker == "." || marker == ")", Number: number}
+
+	for i < len(lines) {
+		m, number, content, text, ok := listMarker(lines[i])
+		if !ok || m != marker {
+			break
+		}
+		item := &Block{Kind: ItemBlock, Line: first + i, Marker: m, Ordered: list.Ordered, Number: number}
+
+		if t := taskMarker.FindStringSubmatch(text); t != nil {
+			item.Task = true
+			item.Checked = t[1] != " "
+			text = text[len(t[0]):]
+		}
+		inner := []string{text}
+
+		j := i + 1
+		for j < len(lines) {
+			line := lines[j]
+			if isBlank(line) {
+				// blank lines only belong to the item when it goes on after them
+				k := j
+				for k < len(lines) && isBlank(lines[k]) {
+					k++
+				}
+				if k == len(lines) || indentOf(lines[k]) < content {
+					break
+				}
+				for ; j < k; j++ {
+					inner = append(inner, "")
+				}
+				continue
+			}
+			if indentOf(line) >= content {
+				inner = append(inner, dropIndent(line, content))
+			} else if !isListItem(line) && !interrupts(line) && !isBlank(inner[len(inner)-1]) {
+				// a paragraph in the item can go on without being indented
+				inner = append(inner, line)
+			} else {
+				break
+			}
+			j++
+		}
+
+		item.Children = parseBlocks(inner, first+i)
+		item.End = first + j
+		list.Children = append(list.Children, item)
+		i = j
+
+		k := i
+		for k < len(lines) && isBlank(lines[k]) {
+			k++
+		}
+		if next, _, _, _, ok := listMarker(safeLine(lines, k)); !ok || next != marker {
+			break
+		}
+		i = k
+	}
+	list.End = first + i
+	return list, i
+}
+
+func safeLine(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
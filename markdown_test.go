@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// previewText renders a note without glyphs or colors, one string per line
+func previewText(note string) []string {
+	var lines []string
+	for _, line := range renderPreview(parseMarkdown(note), strings.Count(note, "\n")+1) {
+		lines = append(lines, line.Prefix+string(line.Runes)+line.Fill)
+	}
+	return lines
+}
+
+func TestListMarker(t *testing.T) {
+	tests := []struct {
+		line    string
+		marker  string
+		number  int
+		content int
+		text    string
+	}{
+		{"- item", "-", 0, 2, "item"},
+		{"-\tlonger text", "-", 0, 4, "longer text"},
+		{"1.\tfoo bar", ".", 1, 4, "foo bar"},
+		{"10)  two spaces", ")", 10, 5, "two spaces"},
+		{"  *\t\tdeep", "*", 0, 4, "    deep"},
+		{"-      code", "-", 0, 2, "     code"},
+		{"-", "-", 0, 2, ""},
+	}
+	for _, test := range tests {
+		marker, number, content, text, ok := listMarker(test.line)
+		if !ok || marker != test.marker || number != test.number || content != test.content || text != test.text {
+			t.Errorf("listMarker(%q) = %q %d %d %q %v, want %q %d %d %q", test.line, marker, number, content, text, ok, test.marker, test.number, test.content, test.text)
+		}
+	}
+	for _, line := range []string{"-item", "---", "* * *", "    - too deep", "1234567890. long"} {
+		if _, _, _, _, ok := listMarker(line); ok {
+			t.Errorf("listMarker(%q) is a list item", line)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		note string
+		want []string
+	}{
+		{"-\tlonger text", []string{"- longer text"}},
+		{"1.\tfoo bar\n2.\tbaz", []string{"1. foo bar", "2. baz"}},
+		{"- a\n\tcontinued", []string{"- a", "  continued"}},
+		{"3. a\n3. b", []string{"3. a", "4. b"}},
+		{"Title\n===\ntext", []string{"Title", "text"}},
+		{"> # Quoted\n> > deeper", []string{"| Quoted", "| | deeper"}},
+		{"```sh\n# not a heading\n```", []string{"sh", "# not a heading"}},
+		{"a\n\n---", []string{"a", "", "-"}},
+		{"a\n\n\n\n\nb", []string{"a", "", "", "", "", "b"}},
+		{"x\n  \n", []string{"x", "", ""}},
+		{"- a\n\n\n- b", []string{"- a", "", "", "- b"}},
+		{"# T\n\n\n", []string{"T", "", "", ""}},
+	}
+	for _, test := range tests {
+		got := previewText(test.note)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("preview of %q = %q, want %q", test.note, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	. "fmt"
+	. "strings"
+)
+
+// glyph picks the symbol to draw for the font the terminal has
+func glyph(nerd, unicode, ascii string) string {
+	if NERD_FONT {
+		return nerd
+	} else if UNICODE {
+		return unicode
+	}
+	return ascii
+}
+
+// PreviewLine is a line of the rendered preview before it is wrapped to the
+// width of the screen
+type PreviewLine struct {
+	// Source is the line of the note it shows, Number is false when the line
+	// number was already shown or the line only decorates a block
+	Source int
+	Number bool
+
+	// Prefix is drawn before the first row and Indent before the rows the
+	// line wraps onto, quotes and list items use them to keep text aligned
+	Prefix string
+	Indent string
+
+	Runes  []rune
+	Styles []Style
+	Color  string
+
+	// Spans color the tokens of highlighted code, lines with spans are drawn
+	// without inline styles
+	Spans []Span
+
+	// Fill is repeated across the whole row, rules are drawn with it
+	Fill string
+}
+
+// previewRenderer walks the blocks of a note, first is drawn before the next
+// line and rest before every line after it, the containers a block is in
+// add their own symbols to both
+type previewRenderer struct {
+	lines []PreviewLine
+	next  int
+	first string
+	rest  string
+
+	// numbered is the last line of the note whose number was shown
+	numbered int
+	// depth is how many lists the current block is in
+	depth int
+}
+
+// renderPreview turns the blocks of a note with count lines into the lines
+// preview mode shows
+func renderPreview(blocks []*Block, count int) []PreviewLine {
+	r := &previewRenderer{numbered: -1}
+	r.blocks(blocks)
+	r.gap(count)
+	return r.lines
+}
+
+func (r *previewRenderer) emit(line PreviewLine) {
+	line.Number = line.Number && line.Source != r.numbered
+	if line.Number {
+		r.numbered = line.Source
+	}
+	line.Prefix, line.Indent = r.first, r.rest
+	if line.Styles == nil {
+		line.Styles = make([]Style, len(line.Runes))
+	}
+	r.lines = append(r.lines, line)
+	r.next = max(r.next, line.Source+1)
+	r.first = r.rest
+}
+
+func (r *previewRenderer) text(source int, text string, color string) {
+	runes, styles := parseInline([]rune(text))
+	r.emit(PreviewLine{Source: source, Number: true, Runes: runes, Styles: styles, Color: color})
+}
+
+// gap keeps the blank lines between blocks up to line
+func (r *previewRenderer) gap(line int) {
+	first := r.first
+	// emit moves r.next past every line it draws
+	for r.next < line {
+		r.first = r.rest
+		r.emit(PreviewLine{Source: r.next, Number: true})
+	}
+	r.first = first
+}
+
+// nest renders blocks inside a container, first and rest are added in front
+// of the first line and the lines after it
+func (r *previewRenderer) nest(blocks []*Block, first, rest string) {
+	outer, drawn := r.rest, len(r.lines)
+	r.first, r.rest = r.first+first, r.rest+rest
+	r.blocks(blocks)
+	if len(r.lines) == drawn {
+		// nothing was drawn, an empty item still gets its bullet
+		r.emit(PreviewLine{Source: r.next, Number: true})
+	}
+	r.first, r.rest = outer, outer
+}
+
+func (r *previewRenderer) blocks(blocks []*Block) {
+	for _, block := range blocks {
+		r.gap(block.Line)
+		r.block(block)
+	}
+}
+
+func (r *previewRenderer) block(b *Block) {
+	switch b.Kind {
+	case HeadingBlock:
+		headings := []string{H1, H2, H3, H4, H5, H6}
+		r.text(b.Line, Join(b.Text, " "), "\x1b[1m"+headings[b.Level-1])
+		// the underline of a setext heading is not shown
+		r.next = b.End
+
+	case ParagraphBlock:
+		for i, text := range b.Text {
+			r.text(b.Lines[i], text, "")
+		}
+
+	case QuoteBlock:
+		bar := glyph(" ", "▏ ", "| ")
+		r.nest(b.Children, bar, bar)
+
+	case ListBlock:
+		// bullets change with every level of nesting, numbers count up from
+		// the first one and are padded so the text of every item lines up
+		bullets := []string{glyph(" ", "• ", "- "), glyph(" ", "◦ ", "* "), glyph(" ", "▪ ", "+ ")}
+		bullet := bullets[r.depth%len(bullets)]
+		last := Sprintf("%d%s ", b.Number+len(b.Children)-1, b.Marker)
+		r.depth++
+		for i, item := range b.Children {
+			r.gap(item.Line)
+			marker := bullet
+			if item.Task && item.Checked {
+				marker = glyph(" ", "☒ ", "x ")
+			} else if item.Task {
+				marker = glyph("󰄱 ", "☐ ", "o ")
+			} else if item.Ordered {
+				number := Sprintf("%d%s ", b.Number+i, item.Marker)
+				marker = pad(len(last)-len(number)) + number
+			}
+			r.nest(item.Children, marker, Repeat(" ", width(marker)))
+		}
+		r.depth--
+
+	case CodeBlock:
+		// the opening fence shows the language, the closing one is not shown
+		if language := b.Language(); language != "" {
+			r.emit(PreviewLine{Source: b.Line, Number: true, Runes: []rune(language), Color: CODELABEL})
+		}
+		var spans [][]Span
+		if syntax := syntaxes[ToLower(b.Language())]; syntax != nil {
+			spans = syntax.highlight(b.Text)
+		}
+		for i, text := range b.Text {
+			line := PreviewLine{Source: b.Lines[i], Number: true, Runes: []rune(text), Color: CODEBLOCK}
+			if spans != nil {
+				line.Spans = spans[i]
+			}
+			r.emit(line)
+		}
+		r.next = b.End
+
+	case TableBlock:
+		for _, line := range tableLines(b) {
+			r.emit(line)
+		}
+		r.next = b.End
+
+	case RuleBlock:
+		r.emit(PreviewLine{Source: b.Line, Number: true, Fill: glyph("─", "─", "-")})
+	}
+}
+
+// drawPreview draws the preview starting with the first line that comes
+// from line offset of the note, until height rows are filled
+func drawPreview(lines []PreviewLine, offset, cols, height, numPadding int, lineWrap string) []string {
+	var frame []string
+	for _, line := range lines {
+		if line.Source < offset {
+			continue
+		}
+		textCols := max(1, cols-numPadding-2-width(line.Prefix))
+		color := LINETEXT + line.Color
+		runes, styles := line.Runes, line.Styles
+		if line.Fill != "" {
+			runes = []rune(Repeat(line.Fill, textCols/max(1, width(line.Fill))))
+			styles = make([]Style, len(runes))
+		}
+		for j, row := range wrap(runes, textCols) {
+			if len(frame) == height {
+				return frame
+			}
+			number, lead := pad(numPadding), line.Prefix
+			if j > 0 {
+				number, lead = pad(numPadding-1)+lineWrap, line.Indent
+			} else if line.Number {
+				number = pad(numPadding-len(Sprint(line.Source+1))) + Sprint(line.Source+1)
+			}
+			var text string
+			if line.Spans != nil {
+				text = paint(runes, row, line.Spans, color)
+			} else {
+				text = paintStyles(runes, styles, row, color)
+			}
+			frame = append(frame, Sprintf("%s%s %s %s%s%s%s\x1b[0m", LINENUM, number, LINETEXT, lead, color, text, pad(textCols-row.Width)))
+		}
+	}
+	return frame
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	. "strings"
+	"unicode/utf8"
+)
+
+// Prompt is a single line of input on the status line, it keeps what was
+// entered before so it can be brought back with up and down
+type Prompt struct {
+	Text []rune
+	Pos  int
+
+	// Paths turns on completing file names with tab
+	Paths bool
+
+	history []string
+	index   int
+	draft   string
+
+	// matches are the completions tab cycles through, they are dropped as
+	// soon as any other key is pressed
+	matches []string
+	match   int
+}
+
+// Reset starts a new input with text already filled in
+func (p *Prompt) Reset(text string) {
+	p.Text = []rune(text)
+	p.Pos = len(p.Text)
+	p.index = len(p.history)
+	p.matches = nil
+}
+
+func (p *Prompt) String() string {
+	return string(p.Text)
+}
+
+// Remember adds the entered text to the history
+func (p *Prompt) Remember() {
+	text := p.String()
+	if text != "" && (len(p.history) == 0 || p.history[len(p.history)-1] != text) {
+		p.history = append(p.history, text)
+	}
+	p.index = len(p.history)
+}
+
+func (p *Prompt) set(text string) {
+	p.Text = []rune(text)
+	p.Pos = len(p.Text)
+}
+
+func (p *Prompt) insert(text string) {
+	runes := []rune(text)
+	p.Text = append(p.Text[:p.Pos], append(runes, p.Text[p.Pos:]...)...)
+	p.Pos += len(runes)
+}
+
+func (p *Prompt) remove(from, to int) {
+	p.Text = append(p.Text[:from], p.Text[to:]...)
+	p.Pos = from
+}
+
+func (p *Prompt) wordLeft() int {
+	i := p.Pos
+	for i > 0 && !isWordChar(p.Text[i-1]) {
+		i--
+	}
+	for i > 0 && isWordChar(p.Text[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (p *Prompt) wordRight() int {
+	i := p.Pos
+	for i < len(p.Text) && !isWordChar(p.Text[i]) {
+		i++
+	}
+	for i < len(p.Text) && isWordChar(p.Text[i]) {
+		i++
+	}
+	return i
+}
+
+// Handle edits the input with key and reports whether the key was used,
+// enter and esc are left to the caller
+func (p *Prompt) Handle(key Key) bool {
+	chord := key.String()
+	if chord != "tab" {
+		p.matches = nil
+	}
+
+	switch chord {
+	case "left", "ctrl+b":
+		if p.Pos > 0 {
+			p.Pos = clusterStart(p.Text, p.Pos)
+		}
+	case "right", "ctrl+f":
+		if p.Pos < len(p.Text) {
+			p.Pos = clusterEnd(p.Text, p.Pos)
+		}
+	case "ctrl+left", "alt+left", "alt+b":
+		p.Pos = p.wordLeft()
+	case "ctrl+right", "alt+right", "alt+f":
+		p.Pos = p.wordRight()
+	case "home", "ctrl+a":
+		p.Pos = 0
+	case "end", "ctrl+e":
+		p.Pos = len(p.Text)
+	case "backspace":
+		if p.Pos > 0 {
+			p.remove(clusterStart(p.Text, p.Pos), p.Pos)
+		}
+	case "delete", "ctrl+d":
+		if p.Pos < len(p.Text) {
+			end := clusterEnd(p.Text, p.Pos)
+			p.remove(p.Pos, end)
+		}
+	case "ctrl+w", "alt+backspace":
+		p.remove(p.wordLeft(), p.Pos)
+	case "alt+d":
+		end := p.wordRight()
+		p.remove(p.Pos, end)
+	case "ctrl+u":
+		p.remove(0, p.Pos)
+	case "ctrl+k":
+		p.remove(p.Pos, len(p.Text))
+	case "up":
+		if p.index > 0 {
+			if p.index == len(p.history) {
+				p.draft = p.String()
+			}
+			p.index--
+			p.set(p.history[p.index])
+		}
+	case "down":
+		if p.index < len(p.history) {
+			p.index++
+			if p.index == len(p.history) {
+				p.set(p.draft)
+			} else {
+				p.set(p.history[p.index])
+			}
+		}
+	case "tab":
+		if !p.Paths {
+			return false
+		}
+		p.complete()
+	case "paste":
+		p.insert(Split(key.Text, "\n")[0])
+	default:
+		if !key.IsChar() {
+			return false
+		}
+		p.insert(key.Name)
+	}
+	return true
+}
+
+// View returns the part of the input that fits in width cells and the cell
+// the cursor is on, the start is cut off when the cursor would not fit
+func (p *Prompt) View(width int) (string, int) {
+	start := 0
+	for start < p.Pos && runesWidth(p.Text[start:p.Pos]) >= width {
+		start = clusterEnd(p.Text, start)
+	}
+	view := p.Text[start:]
+	return clip(string(view), width), runesWidth(p.Text[start:p.Pos])
+}
+
+// expandPath replaces a leading ~ with the home folder and expands $VAR and
+// ${VAR} the way a shell would
+func expandPath(path string) string {
+	if path == "~" || HasPrefix(path, "~/") {
+		path = os.Getenv("HOME") + path[1:]
+	}
+	return os.ExpandEnv(path)
+}
+
+// complete fills in the file name being typed, a name with several matches
+// is completed as far as they agree and pressing tab again cycles them
+func (p *Prompt) complete() {
+	if p.matches != nil {
+		p.match = (p.match + 1) % len(p.matches)
+		p.set(p.matches[p.match])
+		return
+	}
+
+	text := p.String()
+	dir, base := "", text
+	if i := LastIndex(text, "/"); i >= 0 {
+		dir, base = text[:i+1], text[i+1:]
+	}
+	folder := expandPath(dir)
+	if folder == "" {
+		folder = "."
+	}
+
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return
+	}
+	var matches []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !HasPrefix(name, base) || (HasPrefix(name, ".") && !HasPrefix(base, ".")) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(folder, name)); err == nil && info.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, dir+name)
+	}
+	if len(matches) == 0 {
+		return
+	}
+	sort.Strings(matches)
+
+	common := matches[0]
+	for _, match := range matches[1:] {
+		for !HasPrefix(match, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	for !utf8.ValidString(common) {
+		common = common[:len(common)-1]
+	}
+	if len(matches) == 1 || len(common) > len(text) {
+		p.set(common)
+		return
+	}
+	p.matches = matches
+	p.match = 0
+	p.set(matches[0])
+}
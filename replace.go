@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// Substitution goes through the matches of a regular expression inside a part of
+// the note, every replacement is made right away so it can be seen but they
+// are only added to the history together once the replace is finished
+type Substitution struct {
+	Pattern Prompt
+	With    Prompt
+
+	// Line limits the replace to the line the cursor was on
+	Line bool
+
+	re *regexp.Regexp
+
+	// text is the note when the replace began, the matches are all found in
+	// it at once and shift is how far the replacements so far moved them
+	text    string
+	matches [][]int
+	offsets [][2]int
+	next    int
+	shift   int
+
+	// Match is the rune offsets of the match waiting to be confirmed
+	Match [2]int
+	sub   []int
+
+	edits  []Edit
+	before Cursor
+}
+
+// byteIndex turns a rune offset in s into a byte index
+func byteIndex(s string, offset int) int {
+	for i := range s {
+		if offset == 0 {
+			return i
+		}
+		offset--
+	}
+	return len(s)
+}
+
+// Begin compiles the pattern and finds its matches between start and end,
+// the whole note is searched so ^, $ and \b see the text around the range
+func (r *Substitution) Begin(buffer *Buffer, start, end int) error {
+	r.matches, r.offsets = nil, nil
+	r.next, r.shift = 0, 0
+	r.edits = nil
+	r.before = buffer.Cursor()
+	re, err := regexp.Compile(r.Pattern.String())
+	if err != nil {
+		return err
+	}
+	r.re = re
+	r.text = buffer.String()
+
+	from, to := byteIndex(r.text, start), byteIndex(r.text, end)
+	// rune offsets are counted along the way, counting from the start for
+	// every match would make replacing all of them quadratic
+	pos, offset := 0, 0
+	for _, sub := range re.FindAllStringSubmatchIndex(r.text, -1) {
+		if sub[0] > to {
+			break
+		}
+		if sub[0] < from || sub[1] > to {
+			continue
+		}
+		offset += utf8.RuneCountInString(r.text[pos:sub[0]])
+		matchStart := offset
+		offset += utf8.RuneCountInString(r.text[sub[0]:sub[1]])
+		pos = sub[1]
+		r.matches = append(r.matches, sub)
+		r.offsets = append(r.offsets, [2]int{matchStart, offset})
+	}
+	return nil
+}
+
+// Next moves the cursor to the next match, it is false once there are no
+// matches left
+func (r *Substitution) Next(buffer *Buffer) bool {
+	if r.next >= len(r.matches) {
+		return false
+	}
+	r.sub = r.matches[r.next]
+	r.Match = [2]int{r.offsets[r.next][0] + r.shift, r.offsets[r.next][1] + r.shift}
+	buffer.MoveTo(r.Match[0])
+	return true
+}
+
+// Skip leaves the current match as it is
+func (r *Substitution) Skip() {
+	r.next++
+}
+
+// Apply replaces the current match, $1 or ${name} in the replacement are
+// filled in with what the groups of the pattern matched
+func (r *Substitution) Apply(buffer *Buffer) {
+	with := string(r.re.ExpandString(nil, r.With.String(), r.text, r.sub))
+	removed := buffer.Delete(r.Match[0], r.Match[1]-r.Match[0])
+	buffer.Insert(r.Match[0], with)
+	r.edits = append(r.edits, Edit{Pos: r.Match[0], Removed: removed, Inserted: with})
+
+	r.shift += length(with) - length(removed)
+	r.next++
+	buffer.MoveTo(r.Match[0] + length(with))
+}
+
+// Finish records everything that was replaced as one step and returns how
+// many matches were replaced
+func (r *Substitution) Finish(buffer *Buffer, history *History) int {
+	history.RecordGroup(r.edits, r.before, buffer.Cursor())
+	n := len(r.edits)
+	r.edits = nil
+	return n
+}
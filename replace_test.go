@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestSubstitution(t *testing.T) {
+	tests := []struct {
+		pattern, with, text string
+		start, end          int
+		want                string
+	}{
+		{"a", "b", "aaa", 0, 3, "bbb"},
+		{"^a", "b", "aaa", 0, 3, "baa"},
+		{`\bx`, "y", "xxx x", 0, 5, "yxx y"},
+		{"$", "!", "ab\ncd", 0, 5, "ab\ncd!"},
+		{"(?m)^", "> ", "ab\ncd", 0, 5, "> ab\n> cd"},
+		{"x*", "-", "abc", 0, 3, "-a-b-c-"},
+		{`(\w+)@(\w+)`, "$2 at $1", "mail me@home now", 0, 16, "mail home at me now"},
+		{"é", "ee", "éaé日é", 0, 5, "eeaee日ee"},
+		// only matches inside the range are replaced, but anchors still see
+		// the text around it
+		{"^a", "b", "aaa", 1, 3, "aaa"},
+		{"a", "b", "aaa\naaa", 4, 7, "aaa\nbbb"},
+		{`\ba`, "b", "aa a", 1, 4, "aa b"},
+	}
+	for _, test := range tests {
+		buffer := NewBuffer(test.text)
+		var r Substitution
+		r.Pattern.Reset(test.pattern)
+		r.With.Reset(test.with)
+		if err := r.Begin(buffer, test.start, test.end); err != nil {
+			t.Fatalf("%q: %v", test.pattern, err)
+		}
+		for r.Next(buffer) {
+			r.Apply(buffer)
+		}
+		var history History
+		r.Finish(buffer, &history)
+		if got := buffer.String(); got != test.want {
+			t.Errorf("replace %q with %q in %q gave %q, want %q", test.pattern, test.with, test.text, got, test.want)
+		}
+		history.Undo(buffer)
+		if got := buffer.String(); got != test.text {
+			t.Errorf("undoing replace %q in %q gave %q", test.pattern, test.text, got)
+		}
+	}
+}
+
+func TestSubstitutionSkip(t *testing.T) {
+	buffer := NewBuffer("cat cat cat")
+	var r Substitution
+	r.Pattern.Reset("cat")
+	r.With.Reset("dog")
+	r.Begin(buffer, 0, buffer.Len())
+	for i := 0; r.Next(buffer); i++ {
+		if i == 1 {
+			r.Skip()
+		} else {
+			r.Apply(buffer)
+		}
+	}
+	if got := buffer.String(); got != "dog cat dog" {
+		t.Errorf("got %q, want %q", got, "dog cat dog")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// FileState is what a file on disk looked like when it was opened or saved,
+// comparing it with a fresh one tells whether someone else changed the file
+type FileState struct {
+	Exists  bool
+	Size    int64
+	ModTime int64
+}
+
+func statFile(path string) FileState {
+	if path == "" {
+		return FileState{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileState{}
+	}
+	return FileState{true, info.Size(), info.ModTime().UnixNano()}
+}
+
+func backupPath(path string) string {
+	if BACKUP == "timestamp" {
+		return path + "." + time.Now().Format("20060102-150405") + "~"
+	}
+	return path + "~"
+}
+
+// saveFile writes contents to path through a temporary file in the same
+// directory that is renamed over the original, so a crash in the middle of
+// writing never leaves a half written note behind
+//
+// The permissions and owner of an existing file are kept and a backup of it
+// is made first when enabled in the config
+func saveFile(path string, contents string) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		if info.IsDir() {
+			return &os.PathError{Op: "save", Path: path, Err: syscall.EISDIR}
+		}
+		// the rename below would replace a file even when it cannot be
+		// written to, so check it like writing to it directly would
+		if syscall.Access(path, 2) != nil {
+			return errReadOnly
+		}
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if exists {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// only root can give a file away, for everyone else the file
+			// already belongs to them so this failing is fine
+			tmp.Chown(int(stat.Uid), int(stat.Gid))
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if exists && BACKUP != "off" {
+		old, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(backupPath(path), old, mode); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+var errReadOnly = errors.New("file is read-only")
+
+// saveError explains why a save failed in a way that fits the status line
+func saveError(path string, err error) string {
+	switch {
+	case errors.Is(err, errReadOnly):
+		return path + " is read-only"
+	case errors.Is(err, syscall.EROFS):
+		return path + " is on a read-only file system"
+	case errors.Is(err, syscall.EISDIR):
+		return path + " is a folder"
+	case errors.Is(err, syscall.ENOTDIR):
+		return "A part of " + path + " is a file, not a folder"
+	case os.IsPermission(err):
+		return "Permission denied, cannot write to " + path
+	}
+	return "Could not save " + path + ": " + err.Error()
+}
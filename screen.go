@@ -0,0 +1,114 @@
+package main
+
+import (
+	. "fmt"
+	"os"
+	. "strings"
+)
+
+// Screen remembers the last frame that was drawn, drawing a new frame only
+// prints the rows that are different from it instead of the whole screen
+type Screen struct {
+	rows   []string
+	width  int
+	height int
+	cursor string
+}
+
+func (s *Screen) Draw(frame []string, width, height int, cursorPos []int, showCursor bool) {
+	var out Builder
+
+	if width != s.width || height != s.height || s.rows == nil {
+		out.WriteString("\x1b[0m\x1b[2J")
+		s.rows = make([]string, height)
+		s.width, s.height = width, height
+	}
+
+	for i := 0; i < height; i++ {
+		row := ""
+		if i < len(frame) {
+			row = clip(frame[i], width)
+		}
+		if row == s.rows[i] {
+			continue
+		}
+		// rows are already padded to the full width, erasing the rest of the
+		// line after them would also wipe the last cell on some terminals
+		if row == "" {
+			out.WriteString(Sprintf("\x1b[%d;1H\x1b[2K", i+1))
+		} else {
+			out.WriteString(Sprintf("\x1b[%d;1H%s\x1b[0m", i+1, row))
+		}
+		s.rows[i] = row
+	}
+
+	cursor := ""
+	if showCursor {
+		cursor = Sprintf("\x1b[%d;%dH\x1b[?25h", cursorPos[0], cursorPos[1])
+	}
+	if out.Len() == 0 && cursor == s.cursor {
+		return
+	}
+	s.cursor = cursor
+	os.Stdout.WriteString("\x1b[?25l" + out.String() + cursor)
+}
+
+// pad returns n spaces, or nothing when the terminal is too narrow for any
+func pad(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return Repeat(" ", n)
+}
+
+// clip cuts a row of the frame down to width cells so it can never wrap and
+// scroll the screen, escape sequences are kept since they take up no cells
+func clip(row string, width int) string {
+	runes := []rune(row)
+	cells := 0
+	for i := 0; i < len(runes); {
+		if runes[i] == '\x1b' {
+			j := i + 1
+			if j < len(runes) && runes[j] == '[' {
+				j++
+				for j < len(runes) && (runes[j] < 0x40 || runes[j] > 0x7e) {
+					j++
+				}
+			}
+			i = j + 1
+			continue
+		}
+		end := clusterEnd(runes, i)
+		cells += clusterWidth(runes[i:end])
+		if cells > width {
+			return string(runes[:i])
+		}
+		i = end
+	}
+	return row
+}
+
+// Span colors the runes from Start to End of a line
+type Span struct {
+	Start int
+	End   int
+	Color string
+}
+
+// paint renders the part of a line shown on row with the spans in their own
+// colors, spans are sorted and do not overlap, color is used for the rest
+func paint(runes []rune, row WrappedRow, spans []Span, color string) string {
+	var out Builder
+	col := row.Start
+	for _, span := range spans {
+		start, end := max(span.Start, col), min(span.End, row.End)
+		if start >= end {
+			continue
+		}
+		out.WriteString(expandTabs(runes[col:start]))
+		out.WriteString(span.Color + expandTabs(runes[start:end]) + "\x1b[0m" + color)
+		col = end
+	}
+	out.WriteString(expandTabs(runes[col:row.End]))
+	return out.String()
+}
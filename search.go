@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+	"unicode"
+)
+
+// Match is where the search text was found, Start and End are rune columns
+// in the line so they can be compared with the cursor directly
+type Match struct {
+	Row   int
+	Start int
+	End   int
+}
+
+// Search keeps what is being looked for and where it was found, matches are
+// searched again when the query or the case setting changes, an edit only
+// has the lines it changed searched again
+type Search struct {
+	Input         Prompt
+	Query         string
+	CaseSensitive bool
+	Matches       []Match
+
+	found     string
+	sensitive bool
+	// lines is how many lines the note had when it was last searched
+	lines int
+}
+
+func fold(runes []rune) []rune {
+	folded := make([]rune, len(runes))
+	for i, r := range runes {
+		folded[i] = unicode.ToLower(r)
+	}
+	return folded
+}
+
+// Find looks for the query in every line, matches never span lines
+func (s *Search) Find(buffer *Buffer) {
+	from, tail, edited := buffer.Edited()
+	if s.Query != s.found || s.CaseSensitive != s.sensitive {
+		s.Matches = s.Matches[:0]
+		s.find(buffer, 0, buffer.LineCount())
+	} else if edited {
+		// matches before the edit stay, the ones in the untouched lines at
+		// the end only move by the lines that were added or removed
+		end := max(from, buffer.LineCount()-tail)
+		before := sort.Search(len(s.Matches), func(i int) bool { return s.Matches[i].Row >= from })
+		after := sort.Search(len(s.Matches), func(i int) bool { return s.Matches[i].Row >= s.lines-tail })
+		moved := append([]Match{}, s.Matches[max(before, after):]...)
+		s.Matches = s.Matches[:before]
+		s.find(buffer, from, end)
+		for _, m := range moved {
+			m.Row += buffer.LineCount() - s.lines
+			s.Matches = append(s.Matches, m)
+		}
+	}
+	s.found, s.sensitive = s.Query, s.CaseSensitive
+	s.lines = buffer.LineCount()
+}
+
+// find adds the matches in the lines from start up to end
+func (s *Search) find(buffer *Buffer, start, end int) {
+	if s.Query == "" {
+		return
+	}
+	query := []rune(s.Query)
+	if !s.CaseSensitive {
+		query = fold(query)
+	}
+	for row := start; row < end; row++ {
+		line := []rune(buffer.Line(row))
+		if !s.CaseSensitive {
+			line = fold(line)
+		}
+		for col := 0; col+len(query) <= len(line); col++ {
+			if string(line[col:col+len(query)]) == string(query) {
+				s.Matches = append(s.Matches, Match{row, col, col + len(query)})
+				col += len(query) - 1
+			}
+		}
+	}
+}
+
+// Next returns the first match after the cursor, or before it when going
+// backwards, it wraps around the note and is -1 when nothing was found
+//
+// With inclusive set a match right at the cursor counts, that keeps the
+// cursor in place while typing more of the query
+func (s *Search) Next(row, col int, forward, inclusive bool) int {
+	if len(s.Matches) == 0 {
+		return -1
+	}
+	after := func(m Match) bool {
+		if m.Row != row {
+			return m.Row > row
+		}
+		if inclusive {
+			return m.Start >= col
+		}
+		return m.Start > col
+	}
+	i := sort.Search(len(s.Matches), func(i int) bool { return after(s.Matches[i]) })
+	if forward {
+		return i % len(s.Matches)
+	}
+	// i is the first match after the cursor, the one before it is either at
+	// the cursor or before it
+	i--
+	if i >= 0 && s.Matches[i].Row == row && s.Matches[i].Start == col && !inclusive {
+		i--
+	}
+	if i < 0 {
+		i = len(s.Matches) - 1
+	}
+	return i
+}
+
+// Current returns the index of the match the cursor is on, or -1
+func (s *Search) Current(row, col int) int {
+	i := sort.Search(len(s.Matches), func(i int) bool {
+		m := s.Matches[i]
+		return m.Row > row || m.Row == row && m.Start >= col
+	})
+	if i < len(s.Matches) && s.Matches[i].Row == row && s.Matches[i].Start == col {
+		return i
+	}
+	return -1
+}
+
+// Spans returns the matches on a line to be painted in the search colors,
+// current is the index of the match the cursor is on
+func (s *Search) Spans(line int, current int) []Span {
+	i := sort.Search(len(s.Matches), func(i int) bool { return s.Matches[i].Row >= line })
+
+	var spans []Span
+	for ; i < len(s.Matches) && s.Matches[i].Row == line; i++ {
+		color := SEARCH
+		if i == current {
+			color = SEARCHCURRENT
+		}
+		spans = append(spans, Span{s.Matches[i].Start, s.Matches[i].End, color})
+	}
+	return spans
+}
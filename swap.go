@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	. "fmt"
+	"os"
+	"path/filepath"
+	. "strconv"
+	. "strings"
+	"syscall"
+)
+
+// SWAP_FOLDER holds a copy of every note that is being edited, it is written
+// while typing so a killed terminal does not take the unsaved changes with it
+var SWAP_FOLDER = swapFolder()
+
+func swapFolder() string {
+	if state := os.Getenv("XDG_STATE_HOME"); state != "" {
+		return filepath.Join(state, "scratchpad")
+	}
+	return os.ExpandEnv("$HOME/.local/state/scratchpad")
+}
+
+// swapNames are the swaps a file can have, the absolute path is flattened
+// into the name like vim does and later editors of the same file go on with
+// .swo, .swn and so on
+func swapNames(path string) []string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	base := filepath.Join(SWAP_FOLDER, ReplaceAll(path, "/", "%"))
+	var names []string
+	for c := 'p'; c >= 'a'; c-- {
+		names = append(names, base+".sw"+string(c))
+	}
+	return names
+}
+
+// swapPath returns the swap this editor keeps for the note at path, a name
+// that holds another editor's swap is skipped whether that editor is still
+// running or left it behind, notes without a file get the pid in the name
+func swapPath(path string) string {
+	if path == "" {
+		return filepath.Join(SWAP_FOLDER, Sprintf("[No Name].%d.swp", os.Getpid()))
+	}
+	names := swapNames(path)
+	for _, swap := range names {
+		if _, pid, ok := readSwap(swap); !ok || pid == os.Getpid() {
+			return swap
+		}
+	}
+	return names[len(names)-1]
+}
+
+// leftoverSwaps returns the swaps of the note at path that were left behind
+// by editors that are no longer running
+func leftoverSwaps(path string) []string {
+	names := []string{}
+	if path != "" {
+		names = swapNames(path)
+	} else if entries, err := os.ReadDir(SWAP_FOLDER); err == nil {
+		for _, entry := range entries {
+			if HasPrefix(entry.Name(), "[No Name].") && HasSuffix(entry.Name(), ".swp") {
+				names = append(names, filepath.Join(SWAP_FOLDER, entry.Name()))
+			}
+		}
+	}
+	var leftovers []string
+	for _, swap := range names {
+		if _, pid, ok := readSwap(swap); ok && !isRunning(pid) {
+			leftovers = append(leftovers, swap)
+		}
+	}
+	return leftovers
+}
+
+// writeSwap stores contents behind a line with the pid of this editor, the
+// pid tells a leftover swap apart from one another editor is still using
+func writeSwap(swap string, contents string) error {
+	if err := os.MkdirAll(SWAP_FOLDER, 0700); err != nil {
+		return err
+	}
+	tmp := swap + ".tmp"
+	if err := os.WriteFile(tmp, []byte(Sprintf("%d\n%s", os.Getpid(), contents)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, swap)
+}
+
+// readSwap returns what was left in a swap and the pid of the editor that
+// wrote it, ok is false when there is no swap
+func readSwap(swap string) (contents string, pid int, ok bool) {
+	data, err := os.ReadFile(swap)
+	if err != nil {
+		return "", 0, false
+	}
+	header, contents, found := Cut(string(data), "\n")
+	if !found {
+		return "", 0, false
+	}
+	pid, err = Atoi(header)
+	if err != nil {
+		return "", 0, false
+	}
+	return contents, pid, true
+}
+
+func isRunning(pid int) bool {
+	return pid == os.Getpid() || syscall.Kill(pid, 0) == nil
+}
+
+// diffLines lines up a and b, lines only in a start with "- ", lines only in
+// b with "+ " and lines in both with two spaces
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			diff = append(diff, "  "+a[i])
+			i++
+			j++
+		} else if j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]) {
+			diff = append(diff, "+ "+b[j])
+			j++
+		} else {
+			diff = append(diff, "- "+a[i])
+			i++
+		}
+	}
+	return diff
+}
+
+// recoverSwap asks what to do with a swap left behind by an editor that did
+// not exit cleanly, it runs before the terminal is made raw so it can simply
+// read a line, recovered is set when contents was replaced by the swap
+//
+// Only one swap is offered at a time, any others are offered the next time
+// the note is opened
+func recoverSwap(path string, contents string) (string, bool) {
+	var swap, old string
+	for _, leftover := range leftoverSwaps(path) {
+		text, _, _ := readSwap(leftover)
+		if text == contents {
+			os.Remove(leftover)
+			continue
+		}
+		swap, old = leftover, text
+		break
+	}
+	if swap == "" {
+		return contents, false
+	}
+
+	name := path
+	if name == "" {
+		name = "[No Name]"
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		Printf("Found unsaved changes to %s from an editor that did not exit\n", name)
+		Print("(r)ecover them, (d)iff them with the file, d(i)scard them or (q)uit: ")
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			os.Exit(1)
+		}
+		switch TrimSpace(answer) {
+		case "r":
+			// the changes move to this editor's own swap so they are still
+			// kept if it does not exit cleanly either
+			if err := writeSwap(swapPath(path), old); err == nil {
+				os.Remove(swap)
+			}
+			return old, true
+		case "d":
+			for _, line := range diffLines(Split(contents, "\n"), Split(old, "\n")) {
+				Println(line)
+			}
+		case "i":
+			os.Remove(swap)
+			return contents, false
+		case "q":
+			os.Exit(0)
+		}
+	}
+}
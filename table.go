@@ -0,0 +1,188 @@
+package main
+
+import (
+	. "strings"
+)
+
+// tableBorders returns the pieces tables are drawn with, the horizontal and
+// vertical line and then the corners and joints from top left to bottom
+// right
+func tableBorders() []rune {
+	return []rune(glyph("─│╭┬╮├┼┤╰┴╯", "─│┌┬┐├┼┤└┴┘", "-|+++++++++"))
+}
+
+// alignCell pads text to width cells the way a column with align is aligned
+func alignCell(text string, textWidth, width int, align string) string {
+	space := max(0, width-textWidth)
+	switch align {
+	case "right":
+		return pad(space) + text
+	case "center":
+		return pad(space/2) + text + pad(space-space/2)
+	}
+	return text + pad(space)
+}
+
+// tableLines renders a table for preview mode with its columns lined up, the
+// header is bold and the borders only show line numbers where the note has
+// a line for them
+func tableLines(b *Block) []PreviewLine {
+	box := tableBorders()
+	widths := make([]int, len(b.Align))
+	runes := make([][][]rune, len(b.Cells))
+	styles := make([][][]Style, len(b.Cells))
+	for i, row := range b.Cells {
+		runes[i] = make([][]rune, len(row))
+		styles[i] = make([][]Style, len(row))
+		for j, cell := range row {
+			runes[i][j], styles[i][j] = parseInline([]rune(cell))
+			if i == 0 {
+				for k := range styles[i][j] {
+					styles[i][j][k] |= Bold
+				}
+			}
+			widths[j] = max(widths[j], runesWidth(runes[i][j]))
+		}
+	}
+
+	border := func(source int, number bool, left, middle, right rune) PreviewLine {
+		line := PreviewLine{Source: source, Number: number, Runes: []rune{left}}
+		for j, w := range widths {
+			if j > 0 {
+				line.Runes = append(line.Runes, middle)
+			}
+			line.Runes = append(line.Runes, []rune(Repeat(string(box[0]), w+2))...)
+		}
+		line.Runes = append(line.Runes, right)
+		return line
+	}
+	row := func(i int) PreviewLine {
+		line := PreviewLine{Source: b.Lines[i], Number: true}
+		add := func(text []rune, style []Style) {
+			line.Runes = append(line.Runes, text...)
+			if style == nil {
+				style = make([]Style, len(text))
+			}
+			line.Styles = append(line.Styles, style...)
+		}
+		add([]rune{box[1]}, nil)
+		for j := range widths {
+			space := widths[j] - runesWidth(runes[i][j])
+			left := 0
+			if b.Align[j] == "right" {
+				left = space
+			} else if b.Align[j] == "center" {
+				left = space / 2
+			}
+			add([]rune(pad(left+1)), nil)
+			add(runes[i][j], styles[i][j])
+			add([]rune(pad(space-left+1)), nil)
+			add([]rune{box[1]}, nil)
+		}
+		return line
+	}
+
+	last := b.Lines[len(b.Lines)-1]
+	lines := []PreviewLine{border(b.Line, false, box[2], box[3], box[4]), row(0)}
+	lines = append(lines, border(b.Line+1, true, box[5], box[6], box[7]))
+	for i := 1; i < len(b.Cells); i++ {
+		lines = append(lines, row(i))
+	}
+	return append(lines, border(last, false, box[8], box[9], box[10]))
+}
+
+// formatTable lines up the columns of a table in the note, every cell is
+// padded to the widest one in its column and the row of dashes keeps the
+// alignment markers, quote markers and indentation in front of a row stay
+func formatTable(lines []string, align []string) []string {
+	prefixes := make([]string, len(lines))
+	rows := make([][]string, len(lines))
+	columns := len(align)
+	for i, line := range lines {
+		rest := TrimLeft(line, " \t>")
+		prefixes[i] = line[:len(line)-len(rest)]
+		rows[i] = splitRow(rest)
+		columns = max(columns, len(rows[i]))
+	}
+
+	widths := make([]int, columns)
+	for i, row := range rows {
+		for j := range widths {
+			if j < len(row) && i != 1 {
+				widths[j] = max(widths[j], width(row[j]))
+			}
+			// a column needs room for at least three dashes
+			widths[j] = max(widths[j], 3)
+		}
+	}
+
+	formatted := make([]string, len(lines))
+	for i, row := range rows {
+		cells := make([]string, columns)
+		for j := range cells {
+			a := ""
+			if j < len(align) {
+				a = align[j]
+			}
+			if i == 1 {
+				dashes := Repeat("-", widths[j])
+				switch a {
+				case "left":
+					dashes = ":" + dashes[1:]
+				case "right":
+					dashes = dashes[1:] + ":"
+				case "center":
+					dashes = ":" + dashes[2:] + ":"
+				}
+				cells[j] = dashes
+				continue
+			}
+			text := ""
+			if j < len(row) {
+				text = row[j]
+			}
+			cells[j] = alignCell(text, width(text), widths[j], a)
+		}
+		formatted[i] = prefixes[i] + "| " + Join(cells, " | ") + " |"
+	}
+	return formatted
+}
+
+// tableCell returns which cell of a table row col is in, counted by the
+// pipes before it
+func tableCell(line string, col int) int {
+	runes := []rune(line)
+	cell := 0
+	for i := 0; i < col && i < len(runes); i++ {
+		if runes[i] == '\\' {
+			i++
+		} else if runes[i] == '|' {
+			cell++
+		}
+	}
+	return cell
+}
+
+// cellStart returns the column the text of a cell starts at in a formatted
+// row, which is right after the pipe before it and its padding
+func cellStart(line string, cell int) int {
+	runes := []rune(line)
+	if cell == 0 {
+		return 0
+	}
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' {
+			i++
+		} else if runes[i] == '|' {
+			cell--
+			if cell == 0 {
+				i++
+				for i < len(runes)-1 && runes[i] == ' ' {
+					i++
+				}
+				return i
+			}
+		}
+	}
+	return len(runes)
+}
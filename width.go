@@ -0,0 +1,208 @@
+package main
+
+import (
+	. "strings"
+	"unicode"
+)
+
+// wide lists ranges of characters that take up two terminal cells, these are
+// East Asian wide and fullwidth characters and emoji with emoji presentation
+var wide = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a}, {0x23e9, 0x23ec},
+	{0x23f0, 0x23f0}, {0x23f3, 0x23f3}, {0x25fd, 0x25fe}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5}, {0x26ce, 0x26ce},
+	{0x26d4, 0x26d4}, {0x26ea, 0x26ea}, {0x26f2, 0x26f3}, {0x26f5, 0x26f5},
+	{0x26fa, 0x26fa}, {0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27b0, 0x27b0}, {0x27bf, 0x27bf},
+	{0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa4cf},
+	{0xa960, 0xa97f}, {0xac00, 0xd7a3}, {0xf900, 0xfaff}, {0xfe10, 0xfe19},
+	{0xfe30, 0xfe6f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18cff}, {0x1b000, 0x1b2ff}, {0x1f004, 0x1f004}, {0x1f0cf, 0x1f0cf},
+	{0x1f18e, 0x1f18e}, {0x1f191, 0x1f19a}, {0x1f200, 0x1f202}, {0x1f210, 0x1f23b},
+	{0x1f240, 0x1f248}, {0x1f250, 0x1f251}, {0x1f260, 0x1f265}, {0x1f300, 0x1f320},
+	{0x1f32d, 0x1f335}, {0x1f337, 0x1f37c}, {0x1f37e, 0x1f393}, {0x1f3a0, 0x1f3ca},
+	{0x1f3cf, 0x1f3d3}, {0x1f3e0, 0x1f3f0}, {0x1f3f4, 0x1f3f4}, {0x1f3f8, 0x1f43e},
+	{0x1f440, 0x1f440}, {0x1f442, 0x1f4fc}, {0x1f4ff, 0x1f53d}, {0x1f54b, 0x1f54e},
+	{0x1f550, 0x1f567}, {0x1f57a, 0x1f57a}, {0x1f595, 0x1f596}, {0x1f5a4, 0x1f5a4},
+	{0x1f5fb, 0x1f64f}, {0x1f680, 0x1f6c5}, {0x1f6cc, 0x1f6cc}, {0x1f6d0, 0x1f6d2},
+	{0x1f6d5, 0x1f6d7}, {0x1f6dc, 0x1f6df}, {0x1f6eb, 0x1f6ec}, {0x1f6f4, 0x1f6fc},
+	{0x1f7e0, 0x1f7eb}, {0x1f7f0, 0x1f7f0}, {0x1f90c, 0x1f93a}, {0x1f93c, 0x1f945},
+	{0x1f947, 0x1f9ff}, {0x1fa70, 0x1faff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
+func isWide(r rune) bool {
+	lo, hi := 0, len(wide)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		if r < wide[mid][0] {
+			hi = mid - 1
+		} else if r > wide[mid][1] {
+			lo = mid + 1
+		} else {
+			return true
+		}
+	}
+	return false
+}
+
+// isZeroWidth reports characters that do not move the terminal cursor, like
+// combining accents, variation selectors and the zero width joiner
+func isZeroWidth(r rune) bool {
+	if r == 0 || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r) {
+		return true
+	}
+	return r >= 0x1160 && r <= 0x11ff
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// tabs are drawn as this many spaces, printing them as they are would let the
+// terminal jump to its own tab stops and break the layout
+const tabWidth = 4
+
+func expandTabs(runes []rune) string {
+	return ReplaceAll(string(runes), "\t", Repeat(" ", tabWidth))
+}
+
+func runeWidth(r rune) int {
+	if r == '\t' {
+		return tabWidth
+	}
+	if r < ' ' || r == 0x7f || isZeroWidth(r) {
+		return 0
+	}
+	if isWide(r) {
+		return 2
+	}
+	return 1
+}
+
+// clusterEnd returns the index right after the grapheme cluster starting at
+// i, a cluster is a base character together with the combining marks and
+// emoji joiners and modifiers that follow it
+func clusterEnd(runes []rune, i int) int {
+	if i >= len(runes) {
+		return len(runes)
+	}
+	j := i + 1
+	if isRegionalIndicator(runes[i]) && j < len(runes) && isRegionalIndicator(runes[j]) {
+		return j + 1
+	}
+	for j < len(runes) {
+		if runes[j-1] == 0x200d || isZeroWidth(runes[j]) || (runes[j] >= 0x1f3fb && runes[j] <= 0x1f3ff) {
+			j++
+		} else {
+			break
+		}
+	}
+	return j
+}
+
+// clusterStart returns the index where the grapheme cluster ending right
+// before i starts
+func clusterStart(runes []rune, i int) int {
+	start := 0
+	for start < i {
+		end := clusterEnd(runes, start)
+		if end >= i {
+			break
+		}
+		start = end
+	}
+	return start
+}
+
+// snapToCluster moves i back to the start of the cluster it is inside of
+func snapToCluster(runes []rune, i int) int {
+	if i >= len(runes) {
+		return len(runes)
+	}
+	start := 0
+	for start < i {
+		end := clusterEnd(runes, start)
+		if end > i {
+			break
+		}
+		start = end
+	}
+	return start
+}
+
+func clusterWidth(cluster []rune) int {
+	if len(cluster) == 0 {
+		return 0
+	}
+	w := runeWidth(cluster[0])
+	if len(cluster) > 1 {
+		if isRegionalIndicator(cluster[0]) {
+			return 2
+		}
+		for _, r := range cluster[1:] {
+			// emoji presentation selector turns a narrow symbol into an emoji
+			if r == 0xfe0f {
+				return 2
+			}
+		}
+	}
+	return w
+}
+
+func runesWidth(runes []rune) int {
+	w := 0
+	for i := 0; i < len(runes); {
+		end := clusterEnd(runes, i)
+		w += clusterWidth(runes[i:end])
+		i = end
+	}
+	return w
+}
+
+// width returns how many terminal cells s takes up when printed
+func width(s string) int {
+	return runesWidth([]rune(s))
+}
+
+type WrappedRow struct {
+	Start int
+	End   int
+	Width int
+}
+
+// wrap splits a line into rows that fit into cols terminal cells, clusters
+// are never split and a wide character that does not fit moves to next row
+func wrap(runes []rune, cols int) []WrappedRow {
+	rows := []WrappedRow{{}}
+	for i := 0; i < len(runes); {
+		end := clusterEnd(runes, i)
+		w := clusterWidth(runes[i:end])
+		row := &rows[len(rows)-1]
+		if row.Width+w > cols && row.End > row.Start {
+			rows = append(rows, WrappedRow{Start: i, End: i})
+			row = &rows[len(rows)-1]
+		}
+		row.End = end
+		row.Width += w
+		i = end
+	}
+	return rows
+}
+
+// cellToIndex returns the index of the cluster drawn at cell, counted from
+// the start of the row, clicking past the end of the row gives its end
+func cellToIndex(runes []rune, row WrappedRow, cell int) int {
+	w := 0
+	for i := row.Start; i < row.End; {
+		end := clusterEnd(runes, i)
+		w += clusterWidth(runes[i:end])
+		if w > cell {
+			return i
+		}
+		i = end
+	}
+	return row.End
+}